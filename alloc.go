@@ -0,0 +1,31 @@
+package rbtree
+
+// Allocator provides the nodes of a tree, letting them be backed by an
+// arena or a pool instead of the garbage collected heap, see
+// WithAllocator.
+type Allocator[T any] interface {
+	// Alloc returns a node for the tree to use. The tree resets all of
+	// its fields so it may hold stale data.
+	Alloc() *Node[T]
+	// Free hands back a node the tree no longer references.
+	Free(*Node[T])
+}
+
+// allocNode acquires a zeroed node from the allocator, or from the heap
+// when there is none.
+func (r *RBTree[T]) allocNode() *Node[T] {
+	if r.alloc == nil {
+		return new(Node[T])
+	}
+	n := r.alloc.Alloc()
+	*n = Node[T]{}
+	return n
+}
+
+// freeNode returns a node that was physically removed from the tree to
+// the allocator.
+func (r *RBTree[T]) freeNode(n *Node[T]) {
+	if r.alloc != nil {
+		r.alloc.Free(n)
+	}
+}
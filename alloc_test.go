@@ -0,0 +1,194 @@
+package rbtree
+
+import (
+	"cmp"
+	"testing"
+)
+
+// arena hands out nodes from fixed slabs and reuses freed ones.
+type arena struct {
+	slab []Node[int]
+	free []*Node[int]
+	live map[*Node[int]]bool
+
+	allocs, frees int
+}
+
+func newArena() *arena {
+	return &arena{live: make(map[*Node[int]]bool)}
+}
+
+func (a *arena) Alloc() *Node[int] {
+	a.allocs++
+	var n *Node[int]
+	if len(a.free) > 0 {
+		n = a.free[len(a.free)-1]
+		a.free = a.free[:len(a.free)-1]
+	} else {
+		if len(a.slab) == 0 {
+			a.slab = make([]Node[int], 64)
+		}
+		n = &a.slab[0]
+		a.slab = a.slab[1:]
+	}
+	a.live[n] = true
+	return n
+}
+
+func (a *arena) Free(n *Node[int]) {
+	if !a.live[n] {
+		panic("freed a node that is not live")
+	}
+	a.frees++
+	delete(a.live, n)
+	// poison the node so any later use by the tree shows up
+	*n = Node[int]{Value: -1}
+	a.free = append(a.free, n)
+}
+
+// checkArena verifies every node of the tree came from the arena.
+func checkArena(t *testing.T, tree *RBTree[int], a *arena) {
+	t.Helper()
+	isRedBlackTree(t, tree, tree.root)
+	iterator := inOrderIter[int]{tree: tree}
+	iterator.nodes(func(n *Node[int]) bool {
+		if !a.live[n] {
+			t.Errorf("node %d was not allocated by the arena", n.Value)
+		}
+		return true
+	})
+}
+
+func TestAllocator(t *testing.T) {
+	a := newArena()
+	tree := New(cmp.Compare[int], WithAllocator[int](a))
+	for i := 0; i < 100; i++ {
+		tree.Insert(i)
+	}
+	if a.allocs != 100 || a.frees != 0 {
+		t.Errorf("want: 100 allocs got: %d %d", a.allocs, a.frees)
+	}
+	checkArena(t, tree, a)
+
+	for i := 0; i < 10; i++ {
+		tree.Delete(i)
+	}
+	if a.frees != 10 {
+		t.Errorf("want: 10 frees got: %d", a.frees)
+	}
+	// the freed nodes are reused by the next inserts
+	for i := 0; i < 10; i++ {
+		tree.Insert(i)
+	}
+	if a.allocs != 110 || len(a.slab) != 128-100 {
+		t.Errorf("freed nodes were not reused: %d allocs %d slab", a.allocs, len(a.slab))
+	}
+	checkArena(t, tree, a)
+
+	t.Run("DeleteNode", func(t *testing.T) {
+		n := tree.Search(50)
+		tree.DeleteNode(n)
+		if a.frees != 10 || n.Value != 50 {
+			t.Error("DeleteNode must leave the node to the caller")
+		}
+		tree.Insert(50)
+		a.Free(n)
+		checkArena(t, tree, a)
+	})
+
+	t.Run("Bulk", func(t *testing.T) {
+		frees := a.frees
+		if got := tree.ExtractMinN(5); len(got) != 5 || got[4] != 4 {
+			t.Errorf("unexpected values: %#v", got)
+		}
+		tree.DeleteRankRange(0, 5)
+		other := New(cmp.Compare[int])
+		for i := 10; i < 20; i++ {
+			other.Insert(i)
+		}
+		tree.Subtract(other)
+		if a.frees-frees != 20 {
+			t.Errorf("want: 20 frees got: %d", a.frees-frees)
+		}
+		checkArena(t, tree, a)
+
+		frees, before := a.frees, len(a.live)
+		tree.Rebuild([]int{1, 2, 3})
+		if a.frees-frees != before-3 {
+			t.Errorf("want: %d frees got: %d", before-3, a.frees-frees)
+		}
+		if len(a.live) != 3 {
+			t.Errorf("want: 3 live nodes got: %d", len(a.live))
+		}
+		checkArena(t, tree, a)
+
+		var drained []int
+		tree.Drain()(func(v int) bool {
+			drained = append(drained, v)
+			return true
+		})
+		if len(drained) != 3 || drained[2] != 3 || len(a.live) != 0 {
+			t.Errorf("unexpected drain: %#v %d", drained, len(a.live))
+		}
+	})
+
+	t.Run("Vacuum", func(t *testing.T) {
+		a := newArena()
+		tree := New(cmp.Compare[int], WithAllocator[int](a), WithLazyDelete[int]())
+		for i := 0; i < 20; i++ {
+			tree.Insert(i)
+		}
+		for i := 0; i < 20; i += 2 {
+			tree.Delete(i)
+		}
+		if a.frees != 0 {
+			t.Errorf("lazy deletes must not free, got: %d", a.frees)
+		}
+		tree.Vacuum()
+		if a.frees != 10 || len(a.live) != 10 {
+			t.Errorf("want: 10 frees got: %d", a.frees)
+		}
+		checkArena(t, tree, a)
+	})
+
+	t.Run("RebuildFromShape", func(t *testing.T) {
+		a := newArena()
+		tree := New(cmp.Compare[int], WithAllocator[int](a))
+		for i := 0; i < 7; i++ {
+			tree.Insert(i)
+		}
+		shape := tree.Shape()
+
+		if err := tree.RebuildFromShape(shape[:3]); err == nil {
+			t.Error("expected an error for a truncated shape")
+		}
+		if len(a.live) != 7 {
+			t.Errorf("a failed rebuild must free its nodes, %d live", len(a.live))
+		}
+		if err := tree.RebuildFromShape(shape); err != nil {
+			t.Fatal(err)
+		}
+		if len(a.live) != 7 || a.allocs != 7+3+7 {
+			t.Errorf("unexpected arena use: %d live %d allocs", len(a.live), a.allocs)
+		}
+		checkArena(t, tree, a)
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		a := newArena()
+		tree := New(cmp.Compare[int], WithAllocator[int](a), WithLazyDelete[int]())
+		for i := 0; i < 10; i++ {
+			tree.Insert(i)
+		}
+		tree.Delete(5)
+
+		// the refreshed value replaces the deleted node holding it
+		n := tree.Search(4)
+		n.Value = 5
+		tree.Refresh([]*Node[int]{n})
+		if a.frees != 1 || len(a.live) != 9 {
+			t.Errorf("want: 1 free and 9 live got: %d %d", a.frees, len(a.live))
+		}
+		checkArena(t, tree, a)
+	})
+}
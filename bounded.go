@@ -0,0 +1,52 @@
+package rbtree
+
+// BoundedTree keeps only the largest capacity values offered to it,
+// making it suitable for maintaining a top-k over a stream.
+type BoundedTree[T any] struct {
+	tree     *RBTree[T]
+	capacity int
+}
+
+// NewBounded constructs a BoundedTree that holds at most capacity values.
+func NewBounded[T any](compare func(a, b T) int, capacity int) *BoundedTree[T] {
+	return &BoundedTree[T]{tree: New(compare), capacity: capacity}
+}
+
+// Offer val to the tree. If the tree is over capacity afterwards the
+// smallest value is evicted. Returns true if val is in the tree after
+// the call, values that compare equal to one already held are ignored.
+func (b *BoundedTree[T]) Offer(val T) bool {
+	if b.capacity <= 0 {
+		return false
+	}
+
+	if b.tree.Len() >= b.capacity {
+		// avoid the insert and eviction if val would be evicted itself
+		if b.tree.compare(val, b.tree.Min().Value) <= 0 {
+			return false
+		}
+	}
+
+	if _, existing := b.tree.insert(val); existing != nil {
+		return false
+	}
+	if b.tree.Len() > b.capacity {
+		b.tree.DeleteNode(b.tree.Min())
+	}
+	return true
+}
+
+// Len returns the number of values currently held.
+func (b *BoundedTree[T]) Len() int {
+	return b.tree.Len()
+}
+
+// Values returns the values currently held in ascending order.
+func (b *BoundedTree[T]) Values() []T {
+	return b.tree.Flatten()
+}
+
+// Iterate over the values currently held, see RBTree.Iterate.
+func (b *BoundedTree[T]) Iterate(method IterationMethod) func(func(T) bool) {
+	return b.tree.Iterate(method)
+}
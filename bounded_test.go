@@ -0,0 +1,52 @@
+package rbtree
+
+import (
+	"cmp"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestBoundedTree(t *testing.T) {
+	for trial := 0; trial < 10; trial++ {
+		stream := rand.Perm(100)
+		bounded := NewBounded(cmp.Compare[int], 10)
+		for _, v := range stream {
+			bounded.Offer(v)
+			if bounded.Len() > 10 {
+				t.Fatalf("over capacity: %d", bounded.Len())
+			}
+		}
+
+		want := []int{90, 91, 92, 93, 94, 95, 96, 97, 98, 99}
+		if out := bounded.Values(); !slices.Equal(out, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, want)
+		}
+		isRedBlackTree(t, bounded.tree, bounded.tree.root)
+	}
+
+	t.Run("OfferResult", func(t *testing.T) {
+		bounded := NewBounded(cmp.Compare[int], 2)
+		offers := []struct {
+			val  int
+			kept bool
+		}{
+			{5, true}, {3, true}, {1, false}, {3, false}, {4, true}, {5, false}, {9, true},
+		}
+		for _, o := range offers {
+			if kept := bounded.Offer(o.val); kept != o.kept {
+				t.Errorf("offer %d want: %t got: %t", o.val, o.kept, kept)
+			}
+		}
+		if out, want := bounded.Values(), []int{5, 9}; !slices.Equal(out, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, want)
+		}
+	})
+
+	t.Run("ZeroCapacity", func(t *testing.T) {
+		bounded := NewBounded(cmp.Compare[int], 0)
+		if bounded.Offer(1) || bounded.Len() != 0 {
+			t.Error("zero capacity should hold nothing")
+		}
+	})
+}
@@ -0,0 +1,645 @@
+package rbtree
+
+import (
+	"fmt"
+	"math/bits"
+	"slices"
+)
+
+// NewFromUnsorted constructs a tree from vals which may be in any order
+// and contain duplicates. The values are sorted with compare, duplicates
+// are dropped keeping the first occurrence in sorted order and the tree
+// is then bulk-built. vals is not modified.
+func NewFromUnsorted[T any](compare func(a, b T) int, vals []T) *RBTree[T] {
+	r := New(compare)
+	r.root = r.build(sortUnique(compare, slices.Clone(vals)), r.allocNode)
+	return r
+}
+
+// NewFromSeqSorted constructs a tree from all the values yielded by seq
+// which may be in any order and contain duplicates, see NewFromUnsorted.
+//
+// The signature of seq is compatible with iter.Seq.
+func NewFromSeqSorted[T any](compare func(a, b T) int, seq func(func(T) bool)) *RBTree[T] {
+	var vals []T
+	seq(func(v T) bool {
+		vals = append(vals, v)
+		return true
+	})
+
+	r := New(compare)
+	r.root = r.build(sortUnique(compare, vals), r.allocNode)
+	return r
+}
+
+// sortUnique sorts vals in place and removes duplicates, keeping the
+// first of each run of equal values.
+func sortUnique[T any](compare func(a, b T) int, vals []T) []T {
+	slices.SortStableFunc(vals, compare)
+	return slices.CompactFunc(vals, func(a, b T) bool {
+		return compare(a, b) == 0
+	})
+}
+
+// Flatten returns the values of the tree as a sorted slice.
+func (r *RBTree[T]) Flatten() []T {
+	var out []T
+	r.Iterate(InOrder)(func(v T) bool {
+		out = append(out, v)
+		return true
+	})
+	return out
+}
+
+// ToSliceReverse returns the values of the tree as a slice in descending
+// order, the reverse of Flatten.
+func (r *RBTree[T]) ToSliceReverse() []T {
+	out := make([]T, 0, r.Len())
+	for n := r.last(); n != nil; n = r.prev(n) {
+		out = append(out, n.Value)
+	}
+	return out
+}
+
+// Rebuild replaces the contents of the tree with the values of sorted
+// which must be in strictly ascending order according to the comparator.
+// The tree is bulk-built in O(n) into a balanced shape and the existing
+// nodes are reused for the new values where possible.
+//
+// Any previously held node pointers must be considered invalid after
+// calling Rebuild.
+func (r *RBTree[T]) Rebuild(sorted []T) {
+	for i := 1; i < len(sorted); i++ {
+		if r.compare(sorted[i-1], sorted[i]) >= 0 {
+			panic("values not strictly sorted")
+		}
+	}
+
+	var pool []*Node[T]
+	iterator := inOrderIter[T]{tree: r}
+	iterator.nodes(func(n *Node[T]) bool {
+		pool = append(pool, n)
+		return true
+	})
+
+	root := r.build(sorted, func() *Node[T] {
+		if len(pool) == 0 {
+			return r.allocNode()
+		}
+		n := pool[len(pool)-1]
+		pool = pool[:len(pool)-1]
+		*n = Node[T]{}
+		return n
+	})
+	r.tombstones = 0
+	r.setRoot(root)
+	for _, n := range pool {
+		r.freeNode(n)
+	}
+}
+
+// ReplaceAll replaces the contents of the tree with vals, which may be
+// in any order. Duplicates are dropped like in NewFromUnsorted unless
+// WithDuplicates is used. The new tree is bulk-built from fresh nodes in
+// O(n log n) and takes the place of the old one in a single step, see
+// SyncRBTree.ReplaceAll.
+//
+// Any previously held node pointers must be considered invalid after
+// calling ReplaceAll.
+func (r *RBTree[T]) ReplaceAll(vals []T) {
+	r.replaceSorted(r.sortedCopy(vals))
+}
+
+// sortedCopy returns vals sorted by the comparator, without the
+// duplicates unless WithDuplicates is used.
+func (r *RBTree[T]) sortedCopy(vals []T) []T {
+	sorted := slices.Clone(vals)
+	if r.duplicates {
+		slices.SortStableFunc(sorted, r.compare)
+		return sorted
+	}
+	return sortUnique(r.compare, sorted)
+}
+
+// replaceSorted swaps the contents of the tree for a tree built from
+// sorted.
+func (r *RBTree[T]) replaceSorted(sorted []T) {
+	var old []*Node[T]
+	if r.alloc != nil {
+		iterator := inOrderIter[T]{tree: r}
+		iterator.nodes(func(n *Node[T]) bool {
+			old = append(old, n)
+			return true
+		})
+	}
+
+	root := r.build(sorted, r.allocNode)
+	r.tombstones = 0
+	r.setRoot(root)
+	for _, n := range old {
+		r.freeNode(n)
+	}
+}
+
+// InsertMany inserts all of vals. When vals is in ascending order and
+// starts after the current maximum, as with an append only log, the
+// batch is bulk-built and joined onto the tree in O(k + log n) instead
+// of O(k log n). Otherwise the values are inserted one at a time like
+// with Insert, which panics on a duplicate value leaving the values
+// before it inserted.
+func (r *RBTree[T]) InsertMany(vals []T) {
+	if !r.appendable(vals) {
+		for _, v := range vals {
+			r.Insert(v)
+		}
+		return
+	}
+
+	r.stats.Inserts += uint64(len(vals))
+	pivot := r.newNode(vals[0])
+	r.join(pivot, r.build(vals[1:], r.allocNode))
+}
+
+// appendable reports whether vals is a non-empty ascending batch whose
+// values all come after the current maximum.
+func (r *RBTree[T]) appendable(vals []T) bool {
+	if len(vals) == 0 {
+		return false
+	}
+	limit := 0
+	if r.duplicates {
+		limit = 1
+	}
+	for i := 1; i < len(vals); i++ {
+		if r.compare(vals[i-1], vals[i]) >= limit {
+			return false
+		}
+	}
+
+	if r.root == r.nil {
+		return true
+	}
+	test := r.compare(vals[0], r.maximumCached().Value)
+	return test > 0 || (test == 0 && r.duplicates)
+}
+
+// join links the red leaf pivot and the separate tree rooted at right,
+// all of whose values come after pivot, onto the right of the tree.
+//
+// The taller of the two is descended along its inner spine to a black
+// node with the black height of the shorter one. pivot takes the place
+// of that node with it and the shorter tree as children, which keeps the
+// black heights intact, so only a regular insert fixup of pivot remains.
+func (r *RBTree[T]) join(pivot, right *Node[T]) {
+	left := r.root
+	leftHeight, rightHeight := r.spineHeight(left), r.spineHeight(right)
+
+	var parent, x *Node[T]
+	if leftHeight >= rightHeight {
+		x = left
+		for h := leftHeight; x != r.nil && (x.getColor() == red || h != rightHeight); x = x.right {
+			if x.getColor() == black {
+				h--
+			}
+			parent = x
+		}
+		pivot.left, pivot.right = x, right
+	} else {
+		x = right
+		for h := rightHeight; x.getColor() == red || h != leftHeight; x = x.left {
+			if x.getColor() == black {
+				h--
+			}
+			parent = x
+		}
+		pivot.left, pivot.right = left, x
+	}
+
+	pivot.size = pivot.left.size + pivot.right.size + 1
+	for _, child := range []*Node[T]{pivot.left, pivot.right} {
+		if child != r.nil {
+			child.setParent(pivot)
+		}
+	}
+
+	pivot.setParent(parent)
+	added := pivot.size - x.size
+	for p := parent; p != nil; p = p.getParent() {
+		p.size += added
+	}
+
+	switch {
+	case parent == nil:
+		r.setRoot(pivot)
+	case leftHeight >= rightHeight:
+		parent.right = pivot
+	default:
+		parent.left = pivot
+		r.setRoot(right)
+	}
+	r.insertFixup(pivot)
+}
+
+// spineHeight returns the black height of the subtree rooted at n by
+// counting the black nodes along its left spine.
+func (r *RBTree[T]) spineHeight(n *Node[T]) int {
+	height := 0
+	for ; n != r.nil; n = n.left {
+		if n.getColor() == black {
+			height++
+		}
+	}
+	return height
+}
+
+// Swap exchanges the contents of r and other in O(1), which allows
+// building a tree in the background and then publishing it in one step.
+// The comparators and options, including the root change hooks, stay
+// with their trees, so both trees must order values the same way, agree
+// on WithDuplicates and share any WithAllocator. Node pointers remain
+// valid and now belong to the other tree.
+func (r *RBTree[T]) Swap(other *RBTree[T]) {
+	if r == other {
+		return
+	}
+
+	// the sentinel is never handed out
+	visibleRoot := func(t *RBTree[T]) *Node[T] {
+		if t.root == t.nil {
+			return nil
+		}
+		return t.root
+	}
+	rRoot, otherRoot := visibleRoot(r), visibleRoot(other)
+
+	r.root, other.root = other.root, r.root
+	r.nil, other.nil = other.nil, r.nil
+	r.tombstones, other.tombstones = other.tombstones, r.tombstones
+	r.max, other.max = other.max, r.max
+	r.seq, other.seq = other.seq, r.seq
+
+	if rRoot == otherRoot {
+		return
+	}
+	if r.rootHook != nil {
+		r.rootHook(rRoot, otherRoot)
+	}
+	if other.rootHook != nil {
+		other.rootHook(otherRoot, rRoot)
+	}
+}
+
+// build creates a balanced red black tree from sorted values using
+// alloc to acquire nodes, returning the new root.
+func (r *RBTree[T]) build(sorted []T, alloc func() *Node[T]) *Node[T] {
+	nodes := make([]*Node[T], len(sorted))
+	for i, v := range sorted {
+		r.seq++
+		nodes[i] = alloc()
+		nodes[i].Value = v
+		nodes[i].seq = r.seq
+	}
+	return r.link(nodes)
+}
+
+// link arranges nodes, which must be in sorted order, into a balanced
+// red black tree returning the new root. Only the structural fields of
+// the nodes are touched.
+//
+// Each subtree is split around its middle node so every level but the
+// bottom one is full. Coloring only the bottom level red keeps the black
+// height the same along every path.
+func (r *RBTree[T]) link(nodes []*Node[T]) *Node[T] {
+	r.max = nil
+	if len(nodes) == 0 {
+		return r.nil
+	}
+
+	redDepth := bits.Len(uint(len(nodes))) - 1
+	if redDepth == 0 {
+		// a lone root must be black
+		redDepth = -1
+	}
+
+	root := r.linkSubtree(nodes, 0, redDepth)
+	root.setParent(nil)
+	return root
+}
+
+func (r *RBTree[T]) linkSubtree(nodes []*Node[T], depth, redDepth int) *Node[T] {
+	if len(nodes) == 0 {
+		return r.nil
+	}
+
+	mid := len(nodes) / 2
+	n := nodes[mid]
+	n.setColor(black)
+	if depth == redDepth {
+		n.setColor(red)
+	}
+
+	n.left = r.linkSubtree(nodes[:mid], depth+1, redDepth)
+	if n.left != r.nil {
+		n.left.setParent(n)
+	}
+	n.right = r.linkSubtree(nodes[mid+1:], depth+1, redDepth)
+	if n.right != r.nil {
+		n.right.setParent(n)
+	}
+	n.size = len(nodes)
+
+	return n
+}
+
+// TombstoneRatio returns the fraction of the nodes in the tree that are
+// lazily deleted tombstones, 0 for an empty tree.
+func (r *RBTree[T]) TombstoneRatio() float64 {
+	if r.root.size == 0 {
+		return 0
+	}
+	return float64(r.tombstones) / float64(r.root.size)
+}
+
+// Vacuum physically removes all the values marked as deleted in lazy
+// delete mode, rebuilding the tree into a balanced shape in O(n). The
+// nodes of values that were not deleted are kept so pointers to them
+// remain valid.
+func (r *RBTree[T]) Vacuum() {
+	if r.tombstones == 0 {
+		return
+	}
+
+	live := make([]*Node[T], 0, r.Len())
+	dead := make([]*Node[T], 0, r.tombstones)
+	iterator := inOrderIter[T]{tree: r}
+	iterator.nodes(func(n *Node[T]) bool {
+		if n.isTombstone() {
+			dead = append(dead, n)
+		} else {
+			live = append(live, n)
+		}
+		return true
+	})
+
+	r.setRoot(r.link(live))
+	r.tombstones = 0
+	for _, n := range dead {
+		r.freeNode(n)
+	}
+}
+
+// Transform builds a new tree ordered by compare from the result of
+// applying f to every value of src.
+//
+// When f preserves the order of src, which is checked while mapping, the
+// new tree is bulk-built in O(n). Otherwise the mapped values are sorted
+// first. If f maps several values to ones that compare equal only the
+// first in src's order is kept.
+func Transform[T, U any](src *RBTree[T], compare func(a, b U) int, f func(T) U) *RBTree[U] {
+	vals := make([]U, 0, src.Len())
+	sorted := true
+	src.Iterate(InOrder)(func(v T) bool {
+		u := f(v)
+		if sorted && len(vals) > 0 && compare(vals[len(vals)-1], u) >= 0 {
+			sorted = false
+		}
+		vals = append(vals, u)
+		return true
+	})
+
+	if !sorted {
+		vals = sortUnique(compare, vals)
+	}
+
+	out := New(compare)
+	out.root = out.build(vals, out.allocNode)
+	return out
+}
+
+// Mirror reverses the order of the tree in O(n) by swapping the children
+// of every node and reversing the comparator, along with the equivalence
+// of a NewWeak tree. Afterwards in-order iteration yields the values in
+// the opposite order. Mirroring keeps all the red black properties intact
+// and node pointers remain valid.
+func (r *RBTree[T]) Mirror() {
+	compare := r.compare
+	r.compare = func(a, b T) int {
+		return compare(b, a)
+	}
+	if equivalence := r.equivalence; equivalence != nil {
+		r.equivalence = func(a, b T) int {
+			return equivalence(b, a)
+		}
+	}
+
+	iterator := preOrderIter[T]{tree: r}
+	iterator.nodes(func(n *Node[T]) bool {
+		n.left, n.right = n.right, n.left
+		return true
+	})
+	r.max = nil
+}
+
+// Refresh restores the ordering of the tree after the values of nodes
+// were mutated in place. Only the given nodes are unlinked and inserted
+// again which costs O(k log n) for k nodes, far cheaper than rebuilding
+// the whole tree when few values changed. The nodes themselves are
+// reinserted so pointers to them remain valid.
+//
+// Refresh panics if a refreshed value compares equal to another value in
+// the tree, leaving the tree without the nodes that were not yet
+// reinserted.
+func (r *RBTree[T]) Refresh(nodes []*Node[T]) {
+	// this is not a logical change of the contents so keep the op counts
+	inserts, deletes := r.stats.Inserts, r.stats.Deletes
+	defer func() {
+		r.stats.Inserts, r.stats.Deletes = inserts, deletes
+	}()
+
+	tombstones := make([]bool, len(nodes))
+	for i, n := range nodes {
+		tombstones[i] = n.isTombstone()
+		r.DeleteNode(n)
+	}
+
+	for i, n := range nodes {
+		*n = Node[T]{
+			Value: n.Value,
+			aux:   n.aux,
+			seq:   n.seq,
+			size:  1,
+			left:  r.nil,
+			right: r.nil,
+		}
+		n.setColor(red)
+		if tombstones[i] {
+			n.setTombstone(true)
+			r.tombstones++
+		}
+
+		parent, test, existing := r.locate(n.Value)
+		if existing != nil && existing.isTombstone() {
+			// the refreshed value takes precedence over a deleted one
+			r.DeleteNode(existing)
+			r.freeNode(existing)
+			parent, test, existing = r.locate(n.Value)
+		}
+		if existing != nil {
+			panic("duplicate value")
+		}
+
+		if parent == nil {
+			n.setColor(black)
+			r.setRoot(n)
+			continue
+		}
+		r.attach(n, parent, test)
+	}
+}
+
+// Reindex restores the ordering of the tree after any number of values
+// were mutated in place by sorting all of the nodes and relinking them
+// into a balanced shape in O(n log n). The nodes themselves are kept so
+// pointers to them remain valid. See Refresh when only a few values
+// changed.
+//
+// Reindex panics with an ErrDuplicate if two values now compare equal,
+// unless WithDuplicates is used, leaving the tree untouched.
+func (r *RBTree[T]) Reindex() {
+	if err := r.reindex(); err != nil {
+		panic(err)
+	}
+}
+
+func (r *RBTree[T]) reindex() error {
+	nodes := make([]*Node[T], 0, r.root.size)
+	iterator := inOrderIter[T]{tree: r}
+	iterator.nodes(func(n *Node[T]) bool {
+		nodes = append(nodes, n)
+		return true
+	})
+
+	slices.SortStableFunc(nodes, func(a, b *Node[T]) int {
+		return r.compare(a.Value, b.Value)
+	})
+	if !r.duplicates {
+		for i := 1; i < len(nodes); i++ {
+			if r.compare(nodes[i-1].Value, nodes[i].Value) == 0 {
+				return ErrDuplicate[T]{New: nodes[i].Value, Existing: nodes[i-1].Value}
+			}
+		}
+	}
+
+	r.setRoot(r.link(nodes))
+	return nil
+}
+
+// NodeRecord describes one node of the tree as returned by Shape.
+type NodeRecord[T any] struct {
+	Value    T
+	Red      bool
+	HasLeft  bool
+	HasRight bool
+}
+
+// Shape returns the exact structure of the tree, values and colors
+// included, as a pre-order list of records. RebuildFromShape turns it
+// back into an identical tree. Lazily deleted nodes are included as if
+// they were live.
+func (r *RBTree[T]) Shape() []NodeRecord[T] {
+	shape := make([]NodeRecord[T], 0, r.root.size)
+	iterator := preOrderIter[T]{tree: r}
+	iterator.nodes(func(n *Node[T]) bool {
+		shape = append(shape, NodeRecord[T]{
+			Value:    n.Value,
+			Red:      n.getColor() == red,
+			HasLeft:  n.left != r.nil,
+			HasRight: n.right != r.nil,
+		})
+		return true
+	})
+	return shape
+}
+
+// RebuildFromShape replaces the contents of the tree with the structure
+// described by shape, see Shape, without rebalancing. The result must be
+// a valid red black tree ordered by the tree's comparator, otherwise an
+// error wrapping ErrCorrupt is returned and the tree is left unchanged.
+//
+// Any previously held node pointers must be considered invalid after
+// calling RebuildFromShape.
+func (r *RBTree[T]) RebuildFromShape(shape []NodeRecord[T]) error {
+	seq := r.seq
+	rest := shape
+	truncated := false
+	built := make([]*Node[T], 0, len(shape))
+	var build func() *Node[T]
+	build = func() *Node[T] {
+		record := rest[0]
+		rest = rest[1:]
+		seq++
+		n := r.allocNode()
+		*n = Node[T]{Value: record.Value, seq: seq, left: r.nil, right: r.nil}
+		built = append(built, n)
+		if record.Red {
+			n.setColor(red)
+		}
+
+		for _, child := range []struct {
+			has  bool
+			link **Node[T]
+		}{{record.HasLeft, &n.left}, {record.HasRight, &n.right}} {
+			if !child.has {
+				continue
+			}
+			if len(rest) == 0 {
+				truncated = true
+				continue
+			}
+			*child.link = build()
+			(*child.link).setParent(n)
+		}
+		n.size = n.left.size + n.right.size + 1
+		return n
+	}
+
+	root := r.nil
+	if len(rest) > 0 {
+		root = build()
+	}
+	fail := func(err error) error {
+		for _, n := range built {
+			r.freeNode(n)
+		}
+		return err
+	}
+	if truncated || len(rest) > 0 {
+		return fail(fmt.Errorf("%w: shape does not describe exactly %d nodes", ErrCorrupt, len(shape)))
+	}
+
+	candidate := &RBTree[T]{root: root, nil: r.nil, compare: r.compare, duplicates: r.duplicates}
+	if violations := candidate.Verify(); len(violations) > 0 {
+		return fail(fmt.Errorf("%w: %s", ErrCorrupt, violations[0]))
+	}
+	if !candidate.IsSorted() {
+		return fail(fmt.Errorf("%w: shape is not sorted", ErrCorrupt))
+	}
+
+	var old []*Node[T]
+	if r.alloc != nil {
+		iterator := inOrderIter[T]{tree: r}
+		iterator.nodes(func(n *Node[T]) bool {
+			old = append(old, n)
+			return true
+		})
+	}
+
+	r.seq = seq
+	r.tombstones = 0
+	r.setRoot(root)
+	r.max = nil
+	for _, n := range old {
+		r.freeNode(n)
+	}
+	return nil
+}
@@ -0,0 +1,811 @@
+package rbtree
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestFlattenRebuild(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i)
+	}
+
+	flat := tree.Flatten()
+	if want := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}; !slices.Equal(flat, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", flat, want)
+	}
+
+	// perturb: drop some, shift the rest and grow the slice
+	var perturbed []int
+	for _, v := range flat {
+		if v%3 != 0 {
+			perturbed = append(perturbed, v*2)
+		}
+	}
+	perturbed = append(perturbed, 30, 31, 32, 33, 34, 35)
+
+	tree.Rebuild(perturbed)
+	isRedBlackTree(t, tree, tree.root)
+	if out := tree.Flatten(); !slices.Equal(out, perturbed) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, perturbed)
+	}
+	for _, v := range perturbed {
+		if !tree.Has(v) {
+			t.Errorf("missing: %d", v)
+		}
+	}
+
+	// the rebuilt tree must keep working as a normal tree
+	tree.Insert(3)
+	tree.Delete(31)
+	isRedBlackTree(t, tree, tree.root)
+
+	t.Run("Sizes", func(t *testing.T) {
+		for n := 0; n < 70; n++ {
+			vals := make([]int, n)
+			for i := range vals {
+				vals[i] = i
+			}
+
+			tree := New(cmp.Compare[int])
+			tree.Rebuild(vals)
+			isRedBlackTree(t, tree, tree.root)
+			if tree.root.getColor() != black {
+				t.Errorf("root not black for n=%d", n)
+			}
+			if out := tree.Flatten(); !slices.Equal(out, vals) {
+				t.Errorf("n=%d slices differ:\n%#v\n%#v", n, out, vals)
+			}
+		}
+	})
+
+	t.Run("Unsorted", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic")
+			}
+		}()
+		New(cmp.Compare[int]).Rebuild([]int{1, 3, 2})
+	})
+
+	t.Run("Tombstones", func(t *testing.T) {
+		tree := New(cmp.Compare[int], WithLazyDelete[int]())
+		for i := 0; i < 10; i++ {
+			tree.Insert(i)
+		}
+		tree.Delete(3)
+		tree.Delete(4)
+
+		tree.Rebuild([]int{1, 2, 3})
+		if tree.Len() != 3 {
+			t.Errorf("len = %d, want 3", tree.Len())
+		}
+		if tree.TombstoneRatio() != 0 {
+			t.Errorf("tombstone ratio = %v, want 0", tree.TombstoneRatio())
+		}
+	})
+}
+
+func TestNewFromUnsorted(t *testing.T) {
+	vals := []int{5, 3, 9, 3, 1, 5, 5, 7, 2, 9, 8, 1}
+	original := slices.Clone(vals)
+
+	tree := NewFromUnsorted(cmp.Compare[int], vals)
+	isRedBlackTree(t, tree, tree.root)
+	if !slices.Equal(vals, original) {
+		t.Error("input slice was modified")
+	}
+
+	want := []int{1, 2, 3, 5, 7, 8, 9}
+	if out := tree.Flatten(); !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+	if tree.Len() != len(want) {
+		t.Errorf("want: %d got: %d", len(want), tree.Len())
+	}
+
+	t.Run("FirstDuplicateKept", func(t *testing.T) {
+		type pair struct{ key, order int }
+		tree := NewFromUnsorted(func(a, b pair) int { return cmp.Compare(a.key, b.key) }, []pair{
+			{2, 0}, {1, 1}, {2, 2}, {1, 3},
+		})
+		if got := tree.Search(pair{key: 1}).Value.order; got != 1 {
+			t.Errorf("want: 1 got: %d", got)
+		}
+		if got := tree.Search(pair{key: 2}).Value.order; got != 0 {
+			t.Errorf("want: 0 got: %d", got)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		tree := NewFromUnsorted(cmp.Compare[int], nil)
+		if tree.Len() != 0 || tree.root != tree.nil {
+			t.Error("expected an empty tree")
+		}
+		tree.Insert(1)
+		isRedBlackTree(t, tree, tree.root)
+	})
+}
+
+func TestNewFromSeqSorted(t *testing.T) {
+	seq := func(yield func(int) bool) {
+		for _, v := range []int{9, 4, 4, 7, 1, 9, 3, 1} {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+
+	tree := NewFromSeqSorted(cmp.Compare[int], seq)
+	isRedBlackTree(t, tree, tree.root)
+	want := []int{1, 3, 4, 7, 9}
+	if out := tree.Flatten(); !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+
+	// trees are themselves sequences
+	copied := NewFromSeqSorted(cmp.Compare[int], tree.Iterate(PostOrder))
+	isRedBlackTree(t, copied, copied.root)
+	if out := copied.Flatten(); !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+}
+
+func TestLazyDelete(t *testing.T) {
+	tree := New(cmp.Compare[int], WithLazyDelete[int]())
+	nodes := make(map[int]*Node[int])
+	for i := 1; i <= 20; i++ {
+		nodes[i] = tree.Insert(i)
+	}
+
+	for i := 2; i <= 20; i += 2 {
+		if !tree.Delete(i) {
+			t.Errorf("failed to delete: %d", i)
+		}
+	}
+	if tree.Delete(4) {
+		t.Error("deleted a tombstoned value twice")
+	}
+
+	odds := []int{1, 3, 5, 7, 9, 11, 13, 15, 17, 19}
+	if out := tree.Flatten(); !slices.Equal(out, odds) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, odds)
+	}
+	if out := runIterator(tree.Between(4, 12)); !slices.Equal(out, []int{5, 7, 9, 11}) {
+		t.Errorf("tombstones visible in Between: %#v", out)
+	}
+	if tree.Has(4) || tree.Search(4) != nil {
+		t.Error("tombstoned value is visible to search")
+	}
+	if tree.Len() != 10 || tree.root.size != 20 {
+		t.Errorf("want: 10 live of 20 got: %d of %d", tree.Len(), tree.root.size)
+	}
+
+	// re-inserting revives the same node
+	if n := tree.Insert(6); n != nodes[6] || !tree.Has(6) {
+		t.Error("re-insert should revive the tombstoned node")
+	}
+
+	tree.Vacuum()
+	isRedBlackTree(t, tree, tree.root)
+	want := []int{1, 3, 5, 6, 7, 9, 11, 13, 15, 17, 19}
+	if out := tree.Flatten(); !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+	if tree.Len() != len(want) || tree.root.size != len(want) || tree.tombstones != 0 {
+		t.Errorf("vacuum left tombstones: %d of %d", tree.Len(), tree.root.size)
+	}
+	for _, v := range want {
+		if tree.Search(v) != nodes[v] {
+			t.Errorf("node for %d changed during vacuum", v)
+		}
+	}
+
+	t.Run("DrainAndExtract", func(t *testing.T) {
+		tree := New(cmp.Compare[int], WithLazyDelete[int]())
+		for i := 1; i <= 6; i++ {
+			tree.Insert(i)
+		}
+		tree.Delete(1)
+		tree.Delete(3)
+		if out := tree.ExtractMinN(2); !slices.Equal(out, []int{2, 4}) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, []int{2, 4})
+		}
+		tree.Delete(6)
+		if out := runIterator(tree.Drain()); !slices.Equal(out, []int{5}) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, []int{5})
+		}
+		if tree.Len() != 0 || tree.root != tree.nil {
+			t.Error("tree should be empty")
+		}
+	})
+
+	t.Run("Duplicates", func(t *testing.T) {
+		tree := New(cmp.Compare[int], WithLazyDelete[int](), WithDuplicates[int]())
+		for i := 0; i < 7; i++ {
+			tree.Insert(10)
+		}
+		tree.Insert(5)
+		tree.Insert(15)
+
+		for i := 7; i > 0; i-- {
+			if !tree.Has(10) || len(tree.GetGroup(10)) != i {
+				t.Fatalf("want %d live 10s, got: %#v", i, tree.GetGroup(10))
+			}
+			if !tree.Delete(10) {
+				t.Fatalf("failed to delete with %d live 10s left", i)
+			}
+		}
+		if tree.Has(10) || tree.Delete(10) || len(tree.GetGroup(10)) != 0 {
+			t.Error("all the 10s should be deleted")
+		}
+		if out := tree.Flatten(); !slices.Equal(out, []int{5, 15}) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, []int{5, 15})
+		}
+	})
+}
+
+func TestAutoVacuum(t *testing.T) {
+	tree := New(cmp.Compare[int], WithLazyDelete[int](), WithAutoVacuum[int](0.25))
+	if tree.TombstoneRatio() != 0 {
+		t.Errorf("empty tree want: 0 got: %v", tree.TombstoneRatio())
+	}
+	for i := 1; i <= 20; i++ {
+		tree.Insert(i)
+	}
+
+	// 5 of 20 is exactly the threshold which does not trigger a vacuum
+	for i := 1; i <= 5; i++ {
+		tree.Delete(i)
+	}
+	if got := tree.TombstoneRatio(); got != 0.25 {
+		t.Errorf("want: 0.25 got: %v", got)
+	}
+	if tree.root.size != 20 {
+		t.Errorf("vacuumed too early, %d nodes left", tree.root.size)
+	}
+
+	tree.Delete(6)
+	if got := tree.TombstoneRatio(); got != 0 {
+		t.Errorf("want: 0 got: %v", got)
+	}
+	if tree.root.size != 14 || tree.Len() != 14 {
+		t.Errorf("want: 14 nodes got: %d", tree.root.size)
+	}
+	isRedBlackTree(t, tree, tree.root)
+	if out, want := tree.Flatten(), []int{7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}; !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+
+	// without lazy delete the option does nothing
+	eager := New(cmp.Compare[int], WithAutoVacuum[int](0.1))
+	eager.Insert(1)
+	eager.Insert(2)
+	eager.Delete(1)
+	if eager.TombstoneRatio() != 0 || eager.Len() != 1 {
+		t.Error("eager deletes should not leave tombstones")
+	}
+}
+
+func TestTransform(t *testing.T) {
+	src := New(cmp.Compare[int])
+	for _, v := range []int{3, 12, 7, 1, 25, 100} {
+		src.Insert(v)
+	}
+
+	t.Run("Monotonic", func(t *testing.T) {
+		out := Transform(src, cmp.Compare[string], func(v int) string {
+			return fmt.Sprintf("%03d", v)
+		})
+		isRedBlackTree(t, out, out.root)
+		want := []string{"001", "003", "007", "012", "025", "100"}
+		if got := out.Flatten(); !slices.Equal(got, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", got, want)
+		}
+	})
+
+	t.Run("Reordered", func(t *testing.T) {
+		// lexical order differs from numeric order
+		out := Transform(src, cmp.Compare[string], strconv.Itoa)
+		isRedBlackTree(t, out, out.root)
+		want := []string{"1", "100", "12", "25", "3", "7"}
+		if got := out.Flatten(); !slices.Equal(got, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", got, want)
+		}
+	})
+
+	t.Run("Collisions", func(t *testing.T) {
+		out := Transform(src, cmp.Compare[int], func(v int) int { return v % 5 })
+		isRedBlackTree(t, out, out.root)
+		want := []int{0, 1, 2, 3}
+		if got := out.Flatten(); !slices.Equal(got, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", got, want)
+		}
+	})
+}
+
+func TestMirror(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for _, v := range rand.Perm(50) {
+		tree.Insert(v)
+	}
+
+	want := tree.Flatten()
+	slices.Reverse(want)
+
+	tree.Mirror()
+	isRedBlackTree(t, tree, tree.root)
+	if out := tree.Flatten(); !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+	if !tree.IsSorted() {
+		t.Error("tree should be sorted under the reversed comparator")
+	}
+
+	// the mirrored tree must keep working as a normal tree
+	tree.Insert(100)
+	tree.Delete(25)
+	if tree.Min().Value != 100 || tree.Has(25) || !tree.Has(24) {
+		t.Error("mirrored tree is broken")
+	}
+	isRedBlackTree(t, tree, tree.root)
+
+	tree.Mirror()
+	if !slices.IsSorted(tree.Flatten()) {
+		t.Error("mirroring twice should restore ascending order")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for _, v := range rand.Perm(50) {
+		tree.Insert(v * 2)
+	}
+
+	// move a few values to the other end of the tree, odd values are free
+	var nodes []*Node[int]
+	for _, v := range []int{0, 10, 50, 98} {
+		n := tree.Search(v)
+		n.Value = 99 - v
+		nodes = append(nodes, n)
+	}
+	if tree.IsSorted() {
+		t.Fatal("mutating the values should have broken the order")
+	}
+
+	tree.Refresh(nodes)
+	isRedBlackTree(t, tree, tree.root)
+	if !tree.IsSorted() {
+		t.Error("tree should be sorted after refresh")
+	}
+	if tree.Len() != 50 {
+		t.Errorf("want: 50 got: %d", tree.Len())
+	}
+	for _, n := range nodes {
+		if tree.Search(n.Value) != n {
+			t.Errorf("node for %d was replaced", n.Value)
+		}
+	}
+	for _, v := range []int{0, 10, 50, 98} {
+		if tree.Has(v) {
+			t.Errorf("old value %d still present", v)
+		}
+	}
+
+	t.Run("Everything", func(t *testing.T) {
+		tree := New(cmp.Compare[int])
+		var nodes []*Node[int]
+		for i := 0; i < 10; i++ {
+			nodes = append(nodes, tree.Insert(i))
+		}
+		for _, n := range nodes {
+			n.Value = -n.Value
+		}
+
+		tree.Refresh(nodes)
+		isRedBlackTree(t, tree, tree.root)
+		want := []int{-9, -8, -7, -6, -5, -4, -3, -2, -1, 0}
+		if out := tree.Flatten(); !slices.Equal(out, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, want)
+		}
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		tree := New(cmp.Compare[int])
+		tree.Insert(1)
+		n := tree.Insert(2)
+		n.Value = 1
+
+		defer func() {
+			if recover() == nil {
+				t.Error("expected a panic")
+			}
+		}()
+		tree.Refresh([]*Node[int]{n})
+	})
+}
+
+func TestReindex(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	var nodes []*Node[int]
+	for _, v := range rand.Perm(50) {
+		nodes = append(nodes, tree.Insert(v))
+	}
+	for _, n := range nodes {
+		n.Value = (n.Value * 7) % 50
+	}
+
+	tree.Reindex()
+	isRedBlackTree(t, tree, tree.root)
+	if !tree.IsSorted() {
+		t.Error("tree should be sorted after reindex")
+	}
+	for _, n := range nodes {
+		if tree.Search(n.Value) != n {
+			t.Errorf("node for %d was replaced", n.Value)
+		}
+	}
+
+	t.Run("Duplicate", func(t *testing.T) {
+		tree := New(cmp.Compare[int])
+		tree.Insert(1)
+		tree.Insert(2).Value = 1
+
+		defer func() {
+			if _, ok := recover().(ErrDuplicate[int]); !ok {
+				t.Error("expected an ErrDuplicate panic")
+			}
+		}()
+		tree.Reindex()
+	})
+}
+
+func TestShape(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for _, v := range rand.Perm(100) {
+		tree.Insert(v)
+	}
+	for _, v := range rand.Perm(100)[:30] {
+		tree.Delete(v)
+	}
+
+	shape := tree.Shape()
+	out := New(cmp.Compare[int])
+	if err := out.RebuildFromShape(shape); err != nil {
+		t.Fatal(err)
+	}
+	isRedBlackTree(t, out, out.root)
+	if got, want := out.Bracketed(), tree.Bracketed(); got != want {
+		t.Errorf("structure differs:\n%s\n%s", got, want)
+	}
+	if !slices.Equal(out.Shape(), shape) {
+		t.Error("shape of the rebuilt tree differs")
+	}
+	if out.Len() != 70 || out.Select(10).Value != tree.Select(10).Value {
+		t.Error("sizes were not restored")
+	}
+
+	t.Run("Empty", func(t *testing.T) {
+		out := New(cmp.Compare[int])
+		out.Insert(1)
+		if err := out.RebuildFromShape(nil); err != nil {
+			t.Fatal(err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("want: 0 got: %d", out.Len())
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		small := New(cmp.Compare[int])
+		for i := 1; i <= 3; i++ {
+			small.Insert(i)
+		}
+		good := small.Shape()
+
+		tests := []struct {
+			name   string
+			mutate func(shape []NodeRecord[int]) []NodeRecord[int]
+		}{
+			{"Truncated", func(shape []NodeRecord[int]) []NodeRecord[int] { return shape[:2] }},
+			{"Extra", func(shape []NodeRecord[int]) []NodeRecord[int] { return append(shape, NodeRecord[int]{Value: 4}) }},
+			{"RedRoot", func(shape []NodeRecord[int]) []NodeRecord[int] { shape[0].Red = true; return shape }},
+			{"Unsorted", func(shape []NodeRecord[int]) []NodeRecord[int] { shape[1].Value = 5; return shape }},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				out := New(cmp.Compare[int])
+				out.Insert(10)
+				err := out.RebuildFromShape(test.mutate(slices.Clone(good)))
+				if !errors.Is(err, ErrCorrupt) {
+					t.Errorf("expected ErrCorrupt, got: %v", err)
+				}
+				if !slices.Equal(out.Flatten(), []int{10}) {
+					t.Error("tree should be unchanged")
+				}
+			})
+		}
+	})
+}
+
+func TestSwap(t *testing.T) {
+	var hookCalls [][2]*Node[int]
+	front := New(cmp.Compare[int], WithLazyDelete[int](), WithRootChangeHook(func(old, new *Node[int]) {
+		hookCalls = append(hookCalls, [2]*Node[int]{old, new})
+	}))
+	back := New(cmp.Compare[int], WithLazyDelete[int]())
+	for i := 0; i < 100; i++ {
+		front.Insert(i)
+	}
+	for i := 1000; i < 1050; i++ {
+		back.Insert(i)
+	}
+	back.Delete(1010)
+	wantFront, wantBack := back.Flatten(), front.Flatten()
+	frontRoot, backRoot := front.root, back.root
+	seven := front.Search(7)
+	hookCalls = nil
+
+	front.Swap(back)
+	isRedBlackTree(t, front, front.root)
+	isRedBlackTree(t, back, back.root)
+	if got := front.Flatten(); !slices.Equal(got, wantFront) {
+		t.Errorf("slices differ:\n%#v\n%#v", got, wantFront)
+	}
+	if got := back.Flatten(); !slices.Equal(got, wantBack) {
+		t.Errorf("slices differ:\n%#v\n%#v", got, wantBack)
+	}
+	if front.Len() != 49 || back.Len() != 100 || front.Max().Value != 1049 || back.Max().Value != 99 {
+		t.Errorf("unexpected bookkeeping: %d %d", front.Len(), back.Len())
+	}
+	if back.Search(7) != seven {
+		t.Error("node pointers should move with their contents")
+	}
+	if len(hookCalls) != 1 || hookCalls[0] != [2]*Node[int]{frontRoot, backRoot} {
+		t.Errorf("unexpected root hook calls: %v", hookCalls)
+	}
+
+	// both trees keep working on their new contents
+	front.Insert(1010)
+	back.Delete(7)
+	front.Vacuum()
+	isRedBlackTree(t, front, front.root)
+	isRedBlackTree(t, back, back.root)
+	if front.Len() != 50 || back.Len() != 99 || back.Has(7) {
+		t.Errorf("unexpected lengths: %d %d", front.Len(), back.Len())
+	}
+
+	empty := New(cmp.Compare[int])
+	hookCalls = nil
+	front.Swap(empty)
+	if front.Len() != 0 || front.Min() != nil || empty.Len() != 50 {
+		t.Errorf("unexpected lengths: %d %d", front.Len(), empty.Len())
+	}
+	if len(hookCalls) != 1 || hookCalls[0][1] != nil {
+		t.Errorf("unexpected root hook calls: %v", hookCalls)
+	}
+	front.Insert(1)
+	isRedBlackTree(t, front, front.root)
+
+	front.Swap(front)
+	if front.Len() != 1 {
+		t.Errorf("want: 1 got: %d", front.Len())
+	}
+}
+
+func TestInsertMany(t *testing.T) {
+	seq := func(lo, hi int) []int {
+		var vals []int
+		for i := lo; i < hi; i++ {
+			vals = append(vals, i)
+		}
+		return vals
+	}
+
+	var roots int
+	tree := New(cmp.Compare[int], WithLazyDelete[int](), WithRootChangeHook(func(old, new *Node[int]) {
+		roots++
+	}))
+	var want []int
+	batches := [][]int{
+		seq(0, 1),         // a lone value into the empty tree
+		seq(1, 100),       // a batch taller than the tree
+		seq(100, 103),     // a batch far shorter than the tree
+		{50, 60},          // interleaving, inserted one at a time
+		seq(103, 104),     // a single appended value
+		seq(200, 5000),    // much taller again
+		{150, 4999 + 100}, // inside and past the end, not ascending past max
+		nil,
+		seq(5100, 5200),
+	}
+	for i, batch := range batches {
+		for _, v := range batch {
+			if !slices.Contains(want, v) {
+				want = append(want, v)
+			}
+		}
+		slices.Sort(want)
+		if i == 3 {
+			// the interleaving values are already present as tombstones
+			tree.Delete(50)
+			tree.Delete(60)
+		}
+
+		inserts := tree.OpStats().Inserts
+		tree.InsertMany(batch)
+		isRedBlackTree(t, tree, tree.root)
+		if violations := tree.Verify(); violations != nil {
+			t.Fatalf("batch %d: %v", i, violations)
+		}
+		if got := tree.Flatten(); !slices.Equal(got, want) {
+			t.Fatalf("batch %d: slices differ:\n%#v\n%#v", i, got, want)
+		}
+		if tree.Len() != len(want) || tree.Max().Value != want[len(want)-1] {
+			t.Fatalf("batch %d: unexpected length %d or max %d", i, tree.Len(), tree.Max().Value)
+		}
+		if got := tree.OpStats().Inserts - inserts; got != uint64(len(batch)) {
+			t.Errorf("batch %d: want: %d inserts got: %d", i, len(batch), got)
+		}
+	}
+	if roots == 0 {
+		t.Error("root changes were not reported")
+	}
+	for i, v := range want {
+		if tree.Select(i).Value != v {
+			t.Fatalf("select %d disagrees", i)
+		}
+	}
+
+	t.Run("Random", func(t *testing.T) {
+		rng := rand.New(rand.NewSource(5))
+		tree, next := New(cmp.Compare[int]), 0
+		for round := 0; round < 200; round++ {
+			batch := make([]int, rng.Intn(300))
+			for i := range batch {
+				next += 1 + rng.Intn(3)
+				batch[i] = next
+			}
+			tree.InsertMany(batch)
+			if violations := tree.Verify(); violations != nil {
+				t.Fatalf("round %d: %v", round, violations)
+			}
+		}
+		isRedBlackTree(t, tree, tree.root)
+		if !tree.IsSorted() {
+			t.Error("tree is not sorted")
+		}
+	})
+
+	t.Run("Duplicates", func(t *testing.T) {
+		tree := New(cmp.Compare[int], WithDuplicates[int]())
+		tree.InsertMany([]int{1, 2, 2})
+		tree.InsertMany([]int{2, 2, 3})
+		tree.InsertMany([]int{1, 1})
+		isRedBlackTree(t, tree, tree.root)
+		if got, want := tree.Flatten(), []int{1, 1, 1, 2, 2, 2, 2, 3}; !slices.Equal(got, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", got, want)
+		}
+	})
+
+	t.Run("DuplicatePanics", func(t *testing.T) {
+		tree := New(cmp.Compare[int])
+		tree.InsertMany([]int{1, 2, 3})
+		err := try(func() error {
+			tree.InsertMany([]int{4, 5, 5})
+			return nil
+		})
+		if err == nil {
+			t.Error("expected a panic")
+		}
+		if got, want := tree.Flatten(), []int{1, 2, 3, 4, 5}; !slices.Equal(got, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", got, want)
+		}
+	})
+}
+
+// BenchmarkInsertMany appends batches of ascending values to a growing
+// tree, as an append only log does.
+func BenchmarkInsertMany(b *testing.B) {
+	batch := make([]int, 1000)
+	for _, bench := range []struct {
+		name   string
+		insert func(tree *RBTree[int], vals []int)
+	}{
+		{"Batch", (*RBTree[int]).InsertMany},
+		{"Individual", func(tree *RBTree[int], vals []int) {
+			for _, v := range vals {
+				tree.Insert(v)
+			}
+		}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			tree, next := New(cmp.Compare[int]), 0
+			for i := 0; i < b.N; i++ {
+				for j := range batch {
+					batch[j] = next
+					next++
+				}
+				bench.insert(tree, batch)
+			}
+		})
+	}
+}
+
+func TestReplaceAll(t *testing.T) {
+	tree := New(cmp.Compare[int], WithLazyDelete[int]())
+	for i := 0; i < 100; i++ {
+		tree.Insert(i)
+	}
+	tree.Delete(5)
+
+	tree.ReplaceAll([]int{42, 7, 1000, 7, -3})
+	isRedBlackTree(t, tree, tree.root)
+	if got, want := tree.Flatten(), []int{-3, 7, 42, 1000}; !slices.Equal(got, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", got, want)
+	}
+	if tree.Len() != 4 || tree.TombstoneRatio() != 0 || tree.Max().Value != 1000 || tree.Has(5) {
+		t.Errorf("old contents survived: %d values", tree.Len())
+	}
+
+	tree.ReplaceAll(nil)
+	if tree.Len() != 0 || tree.Min() != nil {
+		t.Errorf("want: an empty tree got: %d values", tree.Len())
+	}
+
+	multi := New(cmp.Compare[int], WithDuplicates[int]())
+	multi.ReplaceAll([]int{3, 1, 3, 2})
+	if got, want := multi.Flatten(), []int{1, 2, 3, 3}; !slices.Equal(got, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", got, want)
+	}
+}
+
+func TestToSliceReverse(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		tree := New(cmp.Compare[int])
+		got := tree.ToSliceReverse()
+		if got == nil || len(got) != 0 {
+			t.Errorf("want empty non-nil slice, got: %#v", got)
+		}
+	})
+
+	t.Run("Single", func(t *testing.T) {
+		tree := New(cmp.Compare[int])
+		tree.Insert(7)
+		if got, want := tree.ToSliceReverse(), []int{7}; !slices.Equal(got, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", got, want)
+		}
+	})
+
+	t.Run("Populated", func(t *testing.T) {
+		tree := New(cmp.Compare[int])
+		for _, v := range []int{5, 2, 8, 1, 9, 3, 7, 4, 6, 10} {
+			tree.Insert(v)
+		}
+		got := tree.ToSliceReverse()
+		if want := []int{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}; !slices.Equal(got, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", got, want)
+		}
+		if cap(got) != tree.Len() {
+			t.Errorf("cap = %d, want %d", cap(got), tree.Len())
+		}
+	})
+
+	t.Run("Tombstones", func(t *testing.T) {
+		tree := New(cmp.Compare[int], WithLazyDelete[int]())
+		for i := 1; i <= 6; i++ {
+			tree.Insert(i)
+		}
+		tree.Delete(2)
+		tree.Delete(6)
+		if got, want := tree.ToSliceReverse(), []int{5, 4, 3, 1}; !slices.Equal(got, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", got, want)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package rbtree
+
+import (
+	"cmp"
+	"unsafe"
+)
+
+// ByPointer returns a comparator ordering pointers by their address, for
+// trees keyed on identity rather than on the values pointed to:
+//
+//	seen := New(ByPointer[Conn]())
+//
+// The order is arbitrary but stable because the current Go runtime never
+// moves heap objects. Should a future runtime move objects the tree
+// would silently become unsorted. Pointers to zero sized values may all
+// share one address and compare equal.
+func ByPointer[T any]() func(a, b *T) int {
+	return func(a, b *T) int {
+		return cmp.Compare(uintptr(unsafe.Pointer(a)), uintptr(unsafe.Pointer(b)))
+	}
+}
+
+// NewWeak constructs a tree for values that can be equivalent without
+// being equal, such as strings compared case insensitively. primary
+// defines the equivalence classes and orders them, secondary orders the
+// values within a class and must only return 0 for identical values.
+//
+// Equivalent values are stored next to each other and GetGroup returns
+// the whole class of a value, all other operations use the combined
+// order.
+func NewWeak[T any](primary, secondary func(a, b T) int, opts ...Option[T]) *RBTree[T] {
+	r := New(func(a, b T) int {
+		if test := primary(a, b); test != 0 {
+			return test
+		}
+		return secondary(a, b)
+	}, opts...)
+	r.equivalence = primary
+	return r
+}
@@ -0,0 +1,110 @@
+package rbtree
+
+import (
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestByPointer(t *testing.T) {
+	type item struct {
+		name string
+	}
+
+	tree := New(ByPointer[item]())
+	var items []*item
+	for i := 0; i < 50; i++ {
+		// equal contents but distinct identities
+		p := &item{name: "same"}
+		items = append(items, p)
+		tree.Insert(p)
+	}
+	isRedBlackTree(t, tree, tree.root)
+
+	if tree.Len() != 50 {
+		t.Errorf("want: 50 got: %d", tree.Len())
+	}
+	for _, p := range items {
+		if !tree.Has(p) {
+			t.Errorf("%p is missing", p)
+		}
+	}
+	if tree.Has(&item{name: "same"}) {
+		t.Error("a new pointer with equal contents should not be found")
+	}
+
+	// the order must not change between walks, even across a GC
+	first := tree.Flatten()
+	for i := 1; i < len(first); i++ {
+		if tree.compare(first[i-1], first[i]) >= 0 {
+			t.Fatal("pointers are not in ascending address order")
+		}
+	}
+	runtime.GC()
+	if !slices.Equal(tree.Flatten(), first) || !tree.IsSorted() {
+		t.Error("order changed after a GC")
+	}
+}
+
+func TestNewWeak(t *testing.T) {
+	tree := NewWeak(func(a, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	}, strings.Compare)
+
+	for _, v := range []string{"foo", "Bar", "FOO", "baz", "Foo", "bar", "fOo"} {
+		tree.Insert(v)
+	}
+	isRedBlackTree(t, tree, tree.root)
+
+	// whole classes in order, within a class by the secondary order
+	want := []string{"Bar", "bar", "baz", "FOO", "Foo", "fOo", "foo"}
+	if out := tree.Flatten(); !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+
+	want = []string{"FOO", "Foo", "fOo", "foo"}
+	for _, key := range []string{"foo", "FOO", "fOO"} {
+		if out := tree.GetGroup(key); !slices.Equal(out, want) {
+			t.Errorf("group of %q slices differ:\n%#v\n%#v", key, out, want)
+		}
+	}
+	if out := tree.GetGroup("BAZ"); !slices.Equal(out, []string{"baz"}) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, []string{"baz"})
+	}
+	if out := tree.GetGroup("qux"); len(out) != 0 {
+		t.Errorf("expected an empty group: %#v", out)
+	}
+
+	// exact lookups still use the combined order
+	if !tree.Has("fOo") || tree.Has("fOO") {
+		t.Error("exact lookups should distinguish within a class")
+	}
+	if _, err := tree.InsertChecked("Bar"); err == nil {
+		t.Error("expected a duplicate error")
+	}
+
+	t.Run("Mirror", func(t *testing.T) {
+		tree := NewWeak(func(a, b string) int {
+			return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+		}, strings.Compare)
+		for _, v := range []string{"foo", "Bar", "FOO", "baz", "bar"} {
+			tree.Insert(v)
+		}
+		tree.Mirror()
+		isRedBlackTree(t, tree, tree.root)
+
+		want := []string{"foo", "FOO", "baz", "bar", "Bar"}
+		if out := tree.Flatten(); !slices.Equal(out, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, want)
+		}
+		want = []string{"foo", "FOO"}
+		if out := tree.GetGroup("Foo"); !slices.Equal(out, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, want)
+		}
+		want = []string{"bar", "Bar"}
+		if out := tree.GetGroup("BAR"); !slices.Equal(out, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, want)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package rbtree
+
+// Cursor is a stateful position within a tree that can be stepped
+// forwards and backwards, useful for pagination.
+//
+// A cursor holds on to a node of the tree. Inserting and deleting other
+// values while a cursor is open is safe and stepping will reflect the
+// tree as it is at the time of the step. Deleting the node the cursor
+// is positioned on invalidates the cursor until the next Seek.
+type Cursor[T any] struct {
+	tree    *RBTree[T]
+	current *Node[T]
+}
+
+// NewCursor creates an unpositioned cursor over the tree. The first
+// call to Next on an unpositioned cursor moves to the smallest value,
+// the first call to Prev moves to the largest.
+func (r *RBTree[T]) NewCursor() *Cursor[T] {
+	return &Cursor[T]{tree: r}
+}
+
+// Seek positions the cursor on the smallest value >= val. If there is
+// no such value the cursor becomes unpositioned.
+func (c *Cursor[T]) Seek(val T) {
+	c.current = c.tree.ceiling(val)
+	if c.current != nil && c.current.isTombstone() {
+		c.current = c.tree.next(c.current)
+	}
+}
+
+// Next moves the cursor to the next value and returns it. Returns false
+// and leaves the cursor unpositioned when stepping past the end.
+func (c *Cursor[T]) Next() (T, bool) {
+	if c.current == nil {
+		c.current = c.tree.first()
+	} else {
+		c.current = c.tree.next(c.current)
+	}
+	return c.Value()
+}
+
+// Prev moves the cursor to the previous value and returns it. Returns
+// false and leaves the cursor unpositioned when stepping past the start.
+func (c *Cursor[T]) Prev() (T, bool) {
+	if c.current == nil {
+		c.current = c.tree.last()
+	} else {
+		c.current = c.tree.prev(c.current)
+	}
+	return c.Value()
+}
+
+// Value returns the value the cursor is positioned on, false if the
+// cursor is unpositioned.
+func (c *Cursor[T]) Value() (T, bool) {
+	if c.current == nil {
+		var zero T
+		return zero, false
+	}
+	return c.current.Value, true
+}
+
+// BidirIterator walks the tree in order from an arbitrary position and
+// can change direction at any step without going back to the root.
+//
+// The iterator sits in the gap between two values, Forward returns the
+// value after the gap and Backward the value before it, each moving the
+// gap past the value returned. Calling Backward right after Forward thus
+// returns the same value again. The same rules as Cursor apply to
+// modifying the tree during iteration.
+type BidirIterator[T any] struct {
+	tree *RBTree[T]
+	// next is the node after the gap, nil when the gap is at the end
+	next *Node[T]
+}
+
+// Bidir creates a BidirIterator positioned just before the smallest
+// value >= start, so the first Forward returns Ceiling(start) and the
+// first Backward the largest value < start.
+func (r *RBTree[T]) Bidir(start T) *BidirIterator[T] {
+	next := r.ceiling(start)
+	if next != nil && next.isTombstone() {
+		next = r.next(next)
+	}
+	return &BidirIterator[T]{tree: r, next: next}
+}
+
+// Forward returns the value after the iterator and moves past it, false
+// at the end of the tree.
+func (b *BidirIterator[T]) Forward() (T, bool) {
+	if b.next == nil {
+		var zero T
+		return zero, false
+	}
+
+	v := b.next.Value
+	b.next = b.tree.next(b.next)
+	return v, true
+}
+
+// Backward returns the value before the iterator and moves back past it,
+// false at the start of the tree.
+func (b *BidirIterator[T]) Backward() (T, bool) {
+	var prev *Node[T]
+	if b.next == nil {
+		prev = b.tree.last()
+	} else {
+		prev = b.tree.prev(b.next)
+	}
+	if prev == nil {
+		var zero T
+		return zero, false
+	}
+
+	b.next = prev
+	return prev.Value, true
+}
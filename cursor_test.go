@@ -0,0 +1,181 @@
+package rbtree
+
+import (
+	"cmp"
+	"slices"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i * 10)
+	}
+
+	t.Run("Seek", func(t *testing.T) {
+		c := tree.NewCursor()
+		if _, ok := c.Value(); ok {
+			t.Error("new cursor should be unpositioned")
+		}
+
+		c.Seek(30)
+		if v, ok := c.Value(); !ok || v != 30 {
+			t.Errorf("want: 30 got: %d (%t)", v, ok)
+		}
+		c.Seek(31)
+		if v, ok := c.Value(); !ok || v != 40 {
+			t.Errorf("want: 40 got: %d (%t)", v, ok)
+		}
+		c.Seek(101)
+		if _, ok := c.Value(); ok {
+			t.Error("seeking past the end should unposition the cursor")
+		}
+	})
+
+	t.Run("Stepping", func(t *testing.T) {
+		c := tree.NewCursor()
+		c.Seek(50)
+		steps := []struct {
+			forward bool
+			want    int
+		}{
+			{true, 60}, {true, 70}, {false, 60}, {false, 50}, {false, 40},
+		}
+		for _, s := range steps {
+			var v int
+			var ok bool
+			if s.forward {
+				v, ok = c.Next()
+			} else {
+				v, ok = c.Prev()
+			}
+			if !ok || v != s.want {
+				t.Errorf("want: %d got: %d (%t)", s.want, v, ok)
+			}
+		}
+	})
+
+	t.Run("Edges", func(t *testing.T) {
+		c := tree.NewCursor()
+		if v, ok := c.Next(); !ok || v != 10 {
+			t.Errorf("want: 10 got: %d (%t)", v, ok)
+		}
+		if _, ok := c.Prev(); ok {
+			t.Error("stepping before the start should fail")
+		}
+
+		if v, ok := c.Prev(); !ok || v != 100 {
+			t.Errorf("want: 100 got: %d (%t)", v, ok)
+		}
+		if _, ok := c.Next(); ok {
+			t.Error("stepping past the end should fail")
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		c := New(cmp.Compare[int]).NewCursor()
+		if _, ok := c.Next(); ok {
+			t.Error("next on empty tree should fail")
+		}
+		if _, ok := c.Prev(); ok {
+			t.Error("prev on empty tree should fail")
+		}
+		c.Seek(5)
+		if _, ok := c.Value(); ok {
+			t.Error("seek on empty tree should fail")
+		}
+	})
+
+	t.Run("Mutation", func(t *testing.T) {
+		tree := New(cmp.Compare[int])
+		for i := 1; i <= 10; i++ {
+			tree.Insert(i * 10)
+		}
+		c := tree.NewCursor()
+		c.Seek(50)
+		tree.Insert(55)
+		tree.Delete(60)
+		if v, ok := c.Next(); !ok || v != 55 {
+			t.Errorf("want: 55 got: %d (%t)", v, ok)
+		}
+		if v, ok := c.Next(); !ok || v != 70 {
+			t.Errorf("want: 70 got: %d (%t)", v, ok)
+		}
+	})
+
+	t.Run("LazyDelete", func(t *testing.T) {
+		tree := New(cmp.Compare[int], WithLazyDelete[int]())
+		for i := 0; i < 5; i++ {
+			tree.Insert(i)
+		}
+		tree.Delete(0)
+		tree.Delete(2)
+
+		var forward, backward []int
+		c := tree.NewCursor()
+		for v, ok := c.Next(); ok; v, ok = c.Next() {
+			forward = append(forward, v)
+		}
+		for v, ok := c.Prev(); ok; v, ok = c.Prev() {
+			backward = append(backward, v)
+		}
+		if want := []int{1, 3, 4}; !slices.Equal(forward, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", forward, want)
+		}
+		if want := []int{4, 3, 1}; !slices.Equal(backward, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", backward, want)
+		}
+
+		c.Seek(2)
+		if v, ok := c.Value(); !ok || v != 3 {
+			t.Errorf("want: 3 got: %d (%t)", v, ok)
+		}
+	})
+}
+
+func TestBidir(t *testing.T) {
+	tree := New(cmp.Compare[int], WithLazyDelete[int]())
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i * 10)
+	}
+	tree.Delete(60)
+
+	// F is a step forward, B a step backward, -1 means no value
+	tests := []struct {
+		name  string
+		start int
+		steps string
+		want  []int
+	}{
+		{"Alternating", 45, "FFBFBB", []int{50, 70, 70, 70, 70, 50}},
+		{"BackwardFirst", 45, "BBFF", []int{40, 30, 30, 40}},
+		{"Exact", 30, "FB", []int{30, 30}},
+		{"OnTombstone", 60, "FBB", []int{70, 70, 50}},
+		{"PastEnd", 95, "FFBB", []int{100, -1, 100, 90}},
+		{"BeforeStart", 0, "BFB", []int{-1, 10, 10}},
+		{"AfterMax", 200, "FBF", []int{-1, 100, 100}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			it := tree.Bidir(test.start)
+			var out []int
+			for _, step := range test.steps {
+				var v int
+				var ok bool
+				if step == 'F' {
+					v, ok = it.Forward()
+				} else {
+					v, ok = it.Backward()
+				}
+				if !ok {
+					v = -1
+				}
+				out = append(out, v)
+			}
+			if !slices.Equal(out, test.want) {
+				t.Errorf("slices differ:\n%#v\n%#v", out, test.want)
+			}
+		})
+	}
+}
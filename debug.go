@@ -0,0 +1,511 @@
+package rbtree
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// IsSorted performs a single in-order pass checking that every value
+// is strictly greater than the one before it according to the tree's
+// comparator. This can detect values that were mutated through a held
+// node in a way that changed their ordering.
+func (r *RBTree[T]) IsSorted() bool {
+	return r.IsOrderedBy(r.compare)
+}
+
+// IsOrderedBy is IsSorted using compare instead of the tree's own
+// comparator. A false result means compare disagrees with the order the
+// tree was built in, such as when two parts of a program use different
+// comparators for the same values. With WithDuplicates adjacent values
+// may also compare equal.
+func (r *RBTree[T]) IsOrderedBy(compare func(a, b T) int) bool {
+	limit := 0
+	if r.duplicates {
+		limit = 1
+	}
+	var prev *Node[T]
+	sorted := true
+	iterator := inOrderIter[T]{tree: r}
+	iterator.nodes(func(n *Node[T]) bool {
+		if prev != nil && compare(prev.Value, n.Value) >= limit {
+			sorted = false
+			return false
+		}
+		prev = n
+		return true
+	})
+	return sorted
+}
+
+// LeafDepthRange returns the shortest and longest root-to-leaf paths in
+// the tree, counted as the number of nodes on the path excluding the
+// sentinel. A valid red black tree always satisfies max <= 2*min.
+func (r *RBTree[T]) LeafDepthRange() (min, max int) {
+	if r.root == r.nil {
+		return 0, 0
+	}
+
+	type entry struct {
+		node  *Node[T]
+		depth int
+	}
+
+	min = -1
+	stack := []entry{{r.root, 1}}
+	for len(stack) > 0 {
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		for _, child := range []*Node[T]{e.node.left, e.node.right} {
+			if child != r.nil {
+				stack = append(stack, entry{child, e.depth + 1})
+				continue
+			}
+			if min == -1 || e.depth < min {
+				min = e.depth
+			}
+			if e.depth > max {
+				max = e.depth
+			}
+		}
+	}
+
+	return min, max
+}
+
+// LCA returns the lowest common ancestor of nodes a and b, the deepest
+// node that has both of them as descendants. A node counts as its own
+// descendant so if one node is an ancestor of the other it is returned.
+// Returns nil if either node is nil.
+func (r *RBTree[T]) LCA(a, b *Node[T]) *Node[T] {
+	if a == nil || b == nil {
+		return nil
+	}
+
+	da, db := depth(a), depth(b)
+	for ; da > db; da-- {
+		a = a.getParent()
+	}
+	for ; db > da; db-- {
+		b = b.getParent()
+	}
+	for a != b {
+		a, b = a.getParent(), b.getParent()
+	}
+
+	return a
+}
+
+// PathsToNodes returns the set of nodes on the path from the root to any
+// of nodes, the nodes themselves included. This is the skeleton of the
+// tree a renderer needs to highlight a selection. The walk up from each
+// node stops at the first ancestor that is already in the set, so the
+// cost is the size of the result. nil nodes are ignored.
+func (r *RBTree[T]) PathsToNodes(nodes []*Node[T]) map[*Node[T]]bool {
+	paths := make(map[*Node[T]]bool)
+	for _, n := range nodes {
+		for ; n != nil && !paths[n]; n = n.getParent() {
+			paths[n] = true
+		}
+	}
+	return paths
+}
+
+// BlackDepth returns the number of black nodes on the path from the root
+// down to n, n itself included. In a valid tree this is the same for the
+// deepest node along every path, the black height. Returns 0 for nil.
+func (r *RBTree[T]) BlackDepth(n *Node[T]) int {
+	blacks := 0
+	for ; n != nil; n = n.getParent() {
+		if n.getColor() == black {
+			blacks++
+		}
+	}
+	return blacks
+}
+
+// depth returns the number of ancestors of n.
+func depth[T any](n *Node[T]) int {
+	d := 0
+	for ; n.getParent() != nil; n = n.getParent() {
+		d++
+	}
+	return d
+}
+
+// SearchInstrumented is like Search but also returns the number of
+// times the comparator was called, which is the number of nodes on the
+// search path. Counts well beyond 2*log2(n) point at a broken tree or
+// comparator.
+func (r *RBTree[T]) SearchInstrumented(val T) (*Node[T], int) {
+	comparisons := 0
+	current := r.root
+	for current != r.nil {
+		comparisons++
+		test := r.compare(val, current.Value)
+		if test < 0 {
+			current = current.left
+		} else if test > 0 {
+			current = current.right
+		} else if current.isTombstone() {
+			return nil, comparisons
+		} else {
+			return current, comparisons
+		}
+	}
+
+	return nil, comparisons
+}
+
+// PathTo describes the path a search for val takes from the root, for
+// example:
+//
+//	root(4,black) -> right(6,red) -> left(5,black)
+//
+// If val is not found the path ends with the empty child where the
+// search terminated followed by "not found":
+//
+//	root(4,black) -> right(6,red) -> left(5,black) -> right(nil) not found
+func (r *RBTree[T]) PathTo(val T) string {
+	var builder strings.Builder
+	step := "root"
+	current := r.root
+	for current != r.nil {
+		if step != "root" {
+			builder.WriteString(" -> ")
+		}
+		fmt.Fprintf(&builder, "%s(%v,%s)", step, current.Value, current.getColor())
+
+		test := r.compare(val, current.Value)
+		if test < 0 {
+			step, current = "left", current.left
+		} else if test > 0 {
+			step, current = "right", current.right
+		} else {
+			return builder.String()
+		}
+	}
+
+	if step != "root" {
+		builder.WriteString(" -> ")
+	}
+	fmt.Fprintf(&builder, "%s(nil) not found", step)
+	return builder.String()
+}
+
+// OpStats are counters of the work a tree has done over its lifetime.
+type OpStats struct {
+	// Rotations counts every left and right rotation.
+	Rotations uint64
+	// Recolorings counts every time a node changed color while
+	// rebalancing.
+	Recolorings uint64
+	// Inserts counts values added through Insert and its variants, bulk
+	// builds are not counted.
+	Inserts uint64
+	// Deletes counts values removed through Delete, DeleteNode, Subtract
+	// and Retain, including lazy deletes.
+	Deletes uint64
+}
+
+// OpStats returns the lifetime operation counters of the tree.
+func (r *RBTree[T]) OpStats() OpStats {
+	return r.stats
+}
+
+// Bracketed renders the tree in a compact nested form, each node is its
+// value followed by B or R for its color and then its children in
+// parentheses:
+//
+//	4B(2B(1B,3B),6B(5B,8R(7B,9B(,10R))))
+//
+// An empty child is written as nothing at all, so 9B(,10R) has only a
+// right child, and leaves have no parentheses. The empty tree renders as
+// the empty string. Lazily deleted nodes are included.
+func (r *RBTree[T]) Bracketed() string {
+	var builder strings.Builder
+	r.bracketed(r.root, &builder)
+	return builder.String()
+}
+
+func (r *RBTree[T]) bracketed(n *Node[T], builder *strings.Builder) {
+	if n == r.nil {
+		return
+	}
+
+	letter := 'B'
+	if n.getColor() == red {
+		letter = 'R'
+	}
+	fmt.Fprintf(builder, "%v%c", n.Value, letter)
+	if n.left == r.nil && n.right == r.nil {
+		return
+	}
+
+	builder.WriteByte('(')
+	r.bracketed(n.left, builder)
+	builder.WriteByte(',')
+	r.bracketed(n.right, builder)
+	builder.WriteByte(')')
+}
+
+// ErrCorrupt is wrapped by the errors returned from CheckIntegrity.
+var ErrCorrupt = errors.New("tree structure is corrupt")
+
+// CheckIntegrity walks the entire tree checking the structural links
+// between nodes: every child must point back at its parent, no node may
+// be reachable twice (which would mean a cycle) and the number of nodes
+// must match the size recorded at the root. Every node is visited at most
+// once so it returns an error instead of spinning forever on a cyclic
+// structure.
+func (r *RBTree[T]) CheckIntegrity() error {
+	count, err := r.checkLinks()
+	if err != nil {
+		return err
+	}
+	if count != r.root.size {
+		return fmt.Errorf("%w: found %d nodes but %d are recorded at the root", ErrCorrupt, count, r.root.size)
+	}
+	return nil
+}
+
+// checkLinks does the link checks of CheckIntegrity without trusting any
+// recorded size and returns the number of nodes found.
+func (r *RBTree[T]) checkLinks() (int, error) {
+	if r.root == r.nil {
+		return 0, nil
+	}
+	if r.root.getParent() != nil {
+		return 0, fmt.Errorf("%w: root %v has a parent", ErrCorrupt, r.root.Value)
+	}
+
+	visited := make(map[*Node[T]]struct{}, r.root.size)
+	stack := []*Node[T]{r.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if _, ok := visited[n]; ok {
+			return 0, fmt.Errorf("%w: cycle detected at %v", ErrCorrupt, n.Value)
+		}
+		visited[n] = struct{}{}
+
+		for _, child := range []*Node[T]{n.left, n.right} {
+			if child == nil {
+				return 0, fmt.Errorf("%w: %v has a nil child", ErrCorrupt, n.Value)
+			}
+			if child == r.nil {
+				continue
+			}
+			if child.getParent() != n {
+				return 0, fmt.Errorf("%w: parent pointer of %v does not point at %v", ErrCorrupt, child.Value, n.Value)
+			}
+			stack = append(stack, child)
+		}
+	}
+	return len(visited), nil
+}
+
+// ErrBadComparator is wrapped by the errors returned from
+// CheckComparator.
+var ErrBadComparator = errors.New("comparator is not a strict total order")
+
+// CheckComparator verifies that compare behaves as a strict total order
+// over samples, which must all be distinct values. No two samples may
+// compare equal, swapping the arguments must flip the sign of the result
+// and a < b < c must imply a < c. The first violation found is returned
+// wrapping ErrBadComparator.
+//
+// Transitivity is checked over every triple of samples which is O(n^3),
+// a few hundred samples are plenty to catch most mistakes.
+func CheckComparator[T any](compare func(a, b T) int, samples []T) error {
+	for i, a := range samples {
+		for _, b := range samples[i+1:] {
+			ab, ba := compare(a, b), compare(b, a)
+			if ab == 0 || ba == 0 {
+				return fmt.Errorf("%w: distinct values %v and %v compare equal", ErrBadComparator, a, b)
+			}
+			if (ab < 0) == (ba < 0) {
+				return fmt.Errorf("%w: antisymmetry violated, compare(%v, %v) = %d and compare(%v, %v) = %d",
+					ErrBadComparator, a, b, ab, b, a, ba)
+			}
+		}
+	}
+
+	for i, a := range samples {
+		for j, b := range samples {
+			if i == j || compare(a, b) > 0 {
+				continue
+			}
+			for k, c := range samples {
+				if k == i || k == j || compare(b, c) > 0 {
+					continue
+				}
+				if compare(a, c) > 0 {
+					return fmt.Errorf("%w: transitivity violated, %v < %v and %v < %v but %v > %v",
+						ErrBadComparator, a, b, b, c, a, c)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidateAndRepair checks the whole tree and repairs it where that can
+// be done safely. Broken links such as cycles or bad parent pointers are
+// returned as an ErrCorrupt error since the set of values in the tree is
+// no longer known. When only the ordering, coloring or sizes are wrong,
+// including a red root or a wrong size at the root, the tree is rebuilt
+// with Reindex and repaired is true.
+//
+// If values were mutated so that two of them compare equal an
+// ErrDuplicate is returned and the tree is left as it was.
+func (r *RBTree[T]) ValidateAndRepair() (repaired bool, err error) {
+	if _, err := r.checkLinks(); err != nil {
+		return false, err
+	}
+	if r.IsSorted() && r.root.getColor() == black && r.blackHeight(r.root) >= 0 {
+		return false, nil
+	}
+
+	if err := r.reindex(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// blackHeight returns the number of black nodes on every path from n down
+// to the sentinel, or -1 if the paths disagree, a red node has a red child
+// or a recorded size is wrong. The links must already be known to be
+// sound, see CheckIntegrity.
+func (r *RBTree[T]) blackHeight(n *Node[T]) int {
+	if n == r.nil {
+		return 0
+	}
+	if n.size != n.left.size+n.right.size+1 {
+		return -1
+	}
+	if n.getColor() == red && (n.left.getColor() == red || n.right.getColor() == red) {
+		return -1
+	}
+
+	left, right := r.blackHeight(n.left), r.blackHeight(n.right)
+	if left < 0 || left != right {
+		return -1
+	}
+	if n.getColor() == black {
+		left++
+	}
+	return left
+}
+
+// ViolationKind identifies which property of the tree a Violation breaks.
+type ViolationKind int
+
+const (
+	// RedRedViolation is a red node with a red parent, Node is the child.
+	RedRedViolation ViolationKind = iota
+	// BlackHeightMismatch is a node whose subtrees have differing numbers
+	// of black nodes on their paths down, Node is the root of the subtree.
+	BlackHeightMismatch
+	// RootNotBlack is a red root, Node is the root.
+	RootNotBlack
+	// BrokenParentPointer is a node whose parent pointer does not point at
+	// the node it's a child of, or a root with a parent. Node is the node
+	// with the bad pointer, its subtree is not checked further.
+	BrokenParentPointer
+	// Cycle is a node that is reached a second time on the way down even
+	// though the parent pointers agree, Node is the node reached again
+	// and it is not descended into a second time.
+	Cycle
+)
+
+func (k ViolationKind) String() string {
+	switch k {
+	case RedRedViolation:
+		return "red node with red parent"
+	case BlackHeightMismatch:
+		return "black height mismatch"
+	case RootNotBlack:
+		return "root is not black"
+	case BrokenParentPointer:
+		return "broken parent pointer"
+	case Cycle:
+		return "cycle"
+	default:
+		return fmt.Sprintf("ViolationKind(%d)", int(k))
+	}
+}
+
+// Violation is a single broken property found by Verify.
+type Violation[T any] struct {
+	Kind ViolationKind
+	Node *Node[T]
+}
+
+func (v Violation[T]) String() string {
+	return fmt.Sprintf("%s at %v", v.Kind, v.Node.Value)
+}
+
+// Verify checks the red black properties of the whole tree and returns
+// every violation it finds, nil for a valid tree. Only children whose
+// parent pointer is correct and that have not been visited yet are
+// descended into so a tree with cycles is reported rather than looping
+// forever. See CheckIntegrity for checks of the sizes and IsSorted for
+// the ordering.
+func (r *RBTree[T]) Verify() []Violation[T] {
+	if r.root == r.nil {
+		return nil
+	}
+
+	var violations []Violation[T]
+	if r.root.getParent() != nil {
+		violations = append(violations, Violation[T]{BrokenParentPointer, r.root})
+	}
+	if r.root.getColor() != black {
+		violations = append(violations, Violation[T]{RootNotBlack, r.root})
+	}
+	seen := map[*Node[T]]struct{}{r.root: {}}
+	r.verify(r.root, seen, &violations)
+	return violations
+}
+
+// verify checks the subtree at n appending to violations and returns its
+// black height. Mismatched heights are reported once and the larger
+// height is passed up so the ancestors don't repeat the report.
+func (r *RBTree[T]) verify(n *Node[T], seen map[*Node[T]]struct{}, violations *[]Violation[T]) int {
+	if n == nil || n == r.nil {
+		return 0
+	}
+
+	heights := [2]int{}
+	for i, child := range []*Node[T]{n.left, n.right} {
+		if child == nil || child == r.nil {
+			continue
+		}
+		if child.getParent() != n {
+			*violations = append(*violations, Violation[T]{BrokenParentPointer, child})
+			continue
+		}
+		if _, ok := seen[child]; ok {
+			*violations = append(*violations, Violation[T]{Cycle, child})
+			continue
+		}
+		seen[child] = struct{}{}
+		if n.getColor() == red && child.getColor() == red {
+			*violations = append(*violations, Violation[T]{RedRedViolation, child})
+		}
+		heights[i] = r.verify(child, seen, violations)
+	}
+
+	if heights[0] != heights[1] {
+		*violations = append(*violations, Violation[T]{BlackHeightMismatch, n})
+	}
+	height := max(heights[0], heights[1])
+	if n.getColor() == black {
+		height++
+	}
+	return height
+}
@@ -0,0 +1,652 @@
+package rbtree
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestIsSorted(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i)
+	}
+	if !tree.IsSorted() {
+		t.Error("healthy tree should be sorted")
+	}
+
+	five := tree.Search(5)
+	five.Value = 50
+	if tree.IsSorted() {
+		t.Error("corrupted tree should not be sorted")
+	}
+
+	five.Value = 4
+	if tree.IsSorted() {
+		t.Error("duplicate values should not be sorted")
+	}
+
+	if !New(cmp.Compare[int]).IsSorted() {
+		t.Error("empty tree should be sorted")
+	}
+}
+
+func TestIsOrderedBy(t *testing.T) {
+	type user struct {
+		id   int
+		name string
+	}
+	byID := func(a, b user) int { return cmp.Compare(a.id, b.id) }
+	tree := New(byID)
+	for i, name := range []string{"d", "b", "e", "a", "c"} {
+		tree.Insert(user{i, name})
+	}
+
+	if !tree.IsOrderedBy(byID) {
+		t.Error("the tree's own comparator should agree")
+	}
+	if !tree.IsOrderedBy(func(a, b user) int { return cmp.Compare(a.id*10, b.id*10) }) {
+		t.Error("an equivalent comparator should agree")
+	}
+	if tree.IsOrderedBy(func(a, b user) int { return cmp.Compare(a.name, b.name) }) {
+		t.Error("ordering by name should disagree")
+	}
+	if tree.IsOrderedBy(func(a, b user) int { return cmp.Compare(b.id, a.id) }) {
+		t.Error("the reverse order should disagree")
+	}
+	if tree.IsOrderedBy(func(a, b user) int { return cmp.Compare(a.id/2, b.id/2) }) {
+		t.Error("a comparator with ties should disagree")
+	}
+	if !New(byID).IsOrderedBy(func(a, b user) int { return 1 }) {
+		t.Error("empty tree is ordered by anything")
+	}
+}
+
+func TestLeafDepthRange(t *testing.T) {
+	t.Run("Balanced", func(t *testing.T) {
+		tree := New(cmp.Compare[int])
+		for i := 1; i <= 1000; i++ {
+			tree.Insert(i)
+			min, max := tree.LeafDepthRange()
+			if max > 2*min {
+				t.Fatalf("after %d inserts max %d > 2 * min %d", i, max, min)
+			}
+		}
+
+		// see TestNodeSize for the shape
+		tree = New(cmp.Compare[int])
+		for i := 1; i <= 10; i++ {
+			tree.Insert(i)
+		}
+		if min, max := tree.LeafDepthRange(); min != 3 || max != 5 {
+			t.Errorf("want: 3, 5 got: %d, %d", min, max)
+		}
+	})
+
+	t.Run("Skewed", func(t *testing.T) {
+		// link a chain by hand, bypassing all balancing
+		tree := New(cmp.Compare[int])
+		tree.Insert(1)
+		current := tree.root
+		for i := 2; i <= 6; i++ {
+			n := &Node[int]{Value: i, size: 1, left: tree.nil, right: tree.nil}
+			n.setParent(current)
+			current.right = n
+			current = n
+		}
+
+		min, max := tree.LeafDepthRange()
+		if min != 1 || max != 6 {
+			t.Errorf("want: 1, 6 got: %d, %d", min, max)
+		}
+		if max <= 2*min {
+			t.Error("expected the skew to be detected")
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		if min, max := New(cmp.Compare[int]).LeafDepthRange(); min != 0 || max != 0 {
+			t.Errorf("want: 0, 0 got: %d, %d", min, max)
+		}
+	})
+}
+
+func TestLCA(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i)
+	}
+
+	// see TestNodeSize for the shape
+	tests := []struct {
+		a, b, want int
+	}{
+		{1, 3, 2},
+		{1, 10, 4},
+		{5, 7, 6},
+		{7, 10, 8},
+		{9, 10, 9},
+		{6, 10, 6},
+		{4, 7, 4},
+		{3, 3, 3},
+	}
+	for _, test := range tests {
+		a, b := tree.Search(test.a), tree.Search(test.b)
+		if got := tree.LCA(a, b); got == nil || got.Value != test.want {
+			t.Errorf("LCA(%d, %d) want: %d got: %#v", test.a, test.b, test.want, got)
+		}
+		if got := tree.LCA(b, a); got == nil || got.Value != test.want {
+			t.Errorf("LCA(%d, %d) want: %d got: %#v", test.b, test.a, test.want, got)
+		}
+	}
+
+	if tree.LCA(nil, tree.root) != nil {
+		t.Error("expected nil for a nil node")
+	}
+}
+
+func TestBlackDepth(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i)
+	}
+
+	// 4B(2B(1B,3B),6B(5B,8R(7B,9B(,10R)))), see TestBracketed
+	want := map[int]int{4: 1, 2: 2, 6: 2, 1: 3, 3: 3, 5: 3, 8: 2, 7: 3, 9: 3, 10: 3}
+	for v, depth := range want {
+		if got := tree.BlackDepth(tree.Search(v)); got != depth {
+			t.Errorf("black depth of %d want: %d got: %d", v, depth, got)
+		}
+	}
+	if got := tree.BlackDepth(nil); got != 0 {
+		t.Errorf("want: 0 got: %d", got)
+	}
+
+	// every leaf is as deep in black nodes as the black height
+	height := tree.blackHeight(tree.root)
+	tree.IterateLeaves()(func(v int) bool {
+		if got := tree.BlackDepth(tree.Search(v)); got != height {
+			t.Errorf("leaf %d want: %d got: %d", v, height, got)
+		}
+		return true
+	})
+}
+
+func TestPathsToNodes(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i)
+	}
+
+	// see TestNodeSize for the shape
+	tests := []struct {
+		name     string
+		selected []int
+		want     []int
+	}{
+		{"Spread", []int{1, 7, 10}, []int{1, 2, 4, 6, 7, 8, 9, 10}},
+		{"Nested", []int{5, 6}, []int{4, 5, 6}},
+		{"Root", []int{4}, []int{4}},
+		{"Repeated", []int{3, 3}, []int{2, 3, 4}},
+		{"Nothing", nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var nodes []*Node[int]
+			for _, v := range test.selected {
+				nodes = append(nodes, tree.Search(v))
+			}
+			nodes = append(nodes, nil)
+
+			var got []int
+			for n, ok := range tree.PathsToNodes(nodes) {
+				if !ok {
+					t.Errorf("node %d is in the set but false", n.Value)
+				}
+				got = append(got, n.Value)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("slices differ:\n%#v\n%#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPathTo(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i)
+	}
+
+	tests := []struct {
+		val  int
+		want string
+	}{
+		{4, "root(4,black)"},
+		{5, "root(4,black) -> right(6,black) -> left(5,black)"},
+		{10, "root(4,black) -> right(6,black) -> right(8,red) -> right(9,black) -> right(10,red)"},
+		{0, "root(4,black) -> left(2,black) -> left(1,black) -> left(nil) not found"},
+		{11, "root(4,black) -> right(6,black) -> right(8,red) -> right(9,black) -> right(10,red) -> right(nil) not found"},
+	}
+	for _, test := range tests {
+		if got := tree.PathTo(test.val); got != test.want {
+			t.Errorf("path to %d wrong:\n%s\n%s", test.val, got, test.want)
+		}
+	}
+
+	if got := New(cmp.Compare[int]).PathTo(1); got != "root(nil) not found" {
+		t.Errorf("wrong empty path: %s", got)
+	}
+}
+
+func TestOpStats(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	if stats := tree.OpStats(); stats != (OpStats{}) {
+		t.Errorf("new tree should have no stats: %#v", stats)
+	}
+
+	// inserting 1, 2 then 3 recolors 2 and 1 and rotates once at 1:
+	//
+	//  1B          2B
+	//    \        /  \
+	//     2R  -> 1R   3R
+	//      \
+	//       3R
+	for i := 1; i <= 3; i++ {
+		tree.Insert(i)
+	}
+	want := OpStats{Rotations: 1, Recolorings: 2, Inserts: 3}
+	if stats := tree.OpStats(); stats != want {
+		t.Errorf("stats differ:\n%#v\n%#v", stats, want)
+	}
+
+	// inserting 4 flips the colors of 1, 3 and 2, root is then recolored
+	// back to black with no rotations
+	tree.Insert(4)
+	want = OpStats{Rotations: 1, Recolorings: 6, Inserts: 4}
+	if stats := tree.OpStats(); stats != want {
+		t.Errorf("stats differ:\n%#v\n%#v", stats, want)
+	}
+
+	// duplicates and misses don't count
+	tree.InsertChecked(4)
+	tree.Delete(10)
+	tree.Delete(4)
+	tree.DeleteNode(tree.Search(1))
+	if stats := tree.OpStats(); stats.Inserts != 4 || stats.Deletes != 2 {
+		t.Errorf("want 4 inserts and 2 deletes, got: %#v", stats)
+	}
+
+	t.Run("Lazy", func(t *testing.T) {
+		tree := New(cmp.Compare[int], WithLazyDelete[int]())
+		tree.Insert(1)
+		tree.Delete(1)
+		tree.Insert(1)
+		tree.Delete(1)
+		tree.DeleteNode(tree.root)
+		if stats := tree.OpStats(); stats.Inserts != 2 || stats.Deletes != 2 {
+			t.Errorf("want 2 inserts and 2 deletes, got: %#v", stats)
+		}
+	})
+
+	t.Run("Bulk", func(t *testing.T) {
+		// small removals delete one at a time and large ones relink the
+		// survivors, both must count every removed value
+		for _, removed := range []int{3, 8} {
+			tree := New(cmp.Compare[int])
+			other := New(cmp.Compare[int])
+			for i := 0; i < 10; i++ {
+				tree.Insert(i)
+				if i < removed {
+					other.Insert(i)
+				}
+			}
+			tree.Subtract(other)
+			if got := tree.OpStats().Deletes; got != uint64(removed) {
+				t.Errorf("removed %d: want %d deletes, got: %d", removed, removed, got)
+			}
+		}
+	})
+}
+
+func TestCheckIntegrity(t *testing.T) {
+	newTree := func() *RBTree[int] {
+		tree := New(cmp.Compare[int])
+		for i := 1; i <= 10; i++ {
+			tree.Insert(i)
+		}
+		return tree
+	}
+
+	if err := newTree().CheckIntegrity(); err != nil {
+		t.Errorf("healthy tree reported: %v", err)
+	}
+	if err := New(cmp.Compare[int]).CheckIntegrity(); err != nil {
+		t.Errorf("empty tree reported: %v", err)
+	}
+
+	// see TestNodeSize for the shape
+	tests := []struct {
+		name    string
+		corrupt func(tree *RBTree[int])
+	}{
+		{"ChildCycle", func(tree *RBTree[int]) {
+			// 10 now points back up at the root, making a loop
+			tree.Search(10).right = tree.root
+		}},
+		{"SelfCycle", func(tree *RBTree[int]) {
+			three := tree.Search(3)
+			three.left = three
+		}},
+		{"ParentPointer", func(tree *RBTree[int]) {
+			tree.Search(7).setParent(tree.root)
+		}},
+		{"RootParent", func(tree *RBTree[int]) {
+			tree.root.setParent(tree.Search(5))
+		}},
+		{"Detached", func(tree *RBTree[int]) {
+			tree.Search(2).left = tree.nil
+		}},
+		{"NilChild", func(tree *RBTree[int]) {
+			tree.Search(5).left = nil
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tree := newTree()
+			test.corrupt(tree)
+			err := tree.CheckIntegrity()
+			if !errors.Is(err, ErrCorrupt) {
+				t.Errorf("expected ErrCorrupt, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckComparator(t *testing.T) {
+	samples := []int{5, 3, 8, 1, 9, 2, 7}
+	if err := CheckComparator(cmp.Compare[int], samples); err != nil {
+		t.Errorf("valid comparator reported: %v", err)
+	}
+	if err := CheckComparator(cmp.Compare[int], nil); err != nil {
+		t.Errorf("no samples reported: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		compare func(a, b int) int
+		samples []int
+		want    string
+	}{
+		{"Equal", func(a, b int) int {
+			// only the last digit takes part in the comparison
+			return cmp.Compare(a%10, b%10)
+		}, []int{1, 2, 13, 11}, "compare equal"},
+		{"Antisymmetry", func(a, b int) int {
+			return -1
+		}, samples, "antisymmetry"},
+		{"Transitivity", func(a, b int) int {
+			// rock, paper, scissors
+			if (a+1)%3 == b {
+				return -1
+			}
+			return 1
+		}, []int{0, 1, 2}, "transitivity"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckComparator(test.compare, test.samples)
+			if !errors.Is(err, ErrBadComparator) {
+				t.Fatalf("expected ErrBadComparator, got: %v", err)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("expected %q in: %v", test.want, err)
+			}
+		})
+	}
+}
+
+func TestValidateAndRepair(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i)
+	}
+	if repaired, err := tree.ValidateAndRepair(); repaired || err != nil {
+		t.Errorf("healthy tree want: false, nil got: %t, %v", repaired, err)
+	}
+
+	t.Run("Ordering", func(t *testing.T) {
+		tree := New(cmp.Compare[int])
+		for i := 1; i <= 10; i++ {
+			tree.Insert(i)
+		}
+		tree.Search(2).Value = 20
+		tree.Search(9).Value = -9
+
+		repaired, err := tree.ValidateAndRepair()
+		if !repaired || err != nil {
+			t.Errorf("want: true, nil got: %t, %v", repaired, err)
+		}
+		isRedBlackTree(t, tree, tree.root)
+		want := []int{-9, 1, 3, 4, 5, 6, 7, 8, 10, 20}
+		if out := tree.Flatten(); !slices.Equal(out, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, want)
+		}
+	})
+
+	t.Run("Coloring", func(t *testing.T) {
+		tree := New(cmp.Compare[int])
+		for i := 1; i <= 10; i++ {
+			tree.Insert(i)
+		}
+		tree.Search(5).setColor(red)
+
+		if repaired, err := tree.ValidateAndRepair(); !repaired || err != nil {
+			t.Errorf("want: true, nil got: %t, %v", repaired, err)
+		}
+		isRedBlackTree(t, tree, tree.root)
+	})
+
+	t.Run("RootNotBlack", func(t *testing.T) {
+		tree := New(cmp.Compare[int])
+		for i := 1; i <= 10; i++ {
+			tree.Insert(i)
+		}
+		tree.root.setColor(red)
+
+		if repaired, err := tree.ValidateAndRepair(); !repaired || err != nil {
+			t.Errorf("want: true, nil got: %t, %v", repaired, err)
+		}
+		isRedBlackTree(t, tree, tree.root)
+	})
+
+	t.Run("RootSize", func(t *testing.T) {
+		for _, size := range []int{3, 25} {
+			tree := New(cmp.Compare[int])
+			for i := 1; i <= 10; i++ {
+				tree.Insert(i)
+			}
+			tree.root.size = size
+
+			if repaired, err := tree.ValidateAndRepair(); !repaired || err != nil {
+				t.Errorf("size %d want: true, nil got: %t, %v", size, repaired, err)
+			}
+			isRedBlackTree(t, tree, tree.root)
+			if tree.Len() != 10 || tree.CheckIntegrity() != nil {
+				t.Errorf("size %d was not repaired: %d", size, tree.root.size)
+			}
+		}
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		tree := New(cmp.Compare[int])
+		for i := 1; i <= 10; i++ {
+			tree.Insert(i)
+		}
+		tree.Search(10).right = tree.root
+
+		repaired, err := tree.ValidateAndRepair()
+		if repaired || !errors.Is(err, ErrCorrupt) {
+			t.Errorf("want: false, ErrCorrupt got: %t, %v", repaired, err)
+		}
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		tree := New(cmp.Compare[int])
+		for i := 1; i <= 10; i++ {
+			tree.Insert(i)
+		}
+		tree.Search(2).Value = 9
+
+		repaired, err := tree.ValidateAndRepair()
+		if _, ok := err.(ErrDuplicate[int]); repaired || !ok {
+			t.Errorf("want: false, ErrDuplicate got: %t, %v", repaired, err)
+		}
+	})
+}
+
+func TestBracketed(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	if got := tree.Bracketed(); got != "" {
+		t.Errorf("empty tree want: %q got: %q", "", got)
+	}
+
+	tree.Insert(1)
+	if got := tree.Bracketed(); got != "1B" {
+		t.Errorf("want: %q got: %q", "1B", got)
+	}
+
+	// see TestNodeSize for the shape
+	for i := 2; i <= 10; i++ {
+		tree.Insert(i)
+	}
+	want := "4B(2B(1B,3B),6B(5B,8R(7B,9B(,10R))))"
+	if got := tree.Bracketed(); got != want {
+		t.Errorf("want: %q got: %q", want, got)
+	}
+
+	// the fixup rotates 6 up to the root
+	tree.Delete(1)
+	isRedBlackTree(t, tree, tree.root)
+	want = "6B(4B(2B(,3R),5B),8B(7B,9B(,10R)))"
+	if got := tree.Bracketed(); got != want {
+		t.Errorf("want: %q got: %q", want, got)
+	}
+}
+
+func TestSearchInstrumented(t *testing.T) {
+	calls := 0
+	tree := New(func(a, b int) int {
+		calls++
+		return cmp.Compare(a, b)
+	})
+	for i := 1; i <= 100; i++ {
+		tree.Insert(i * 2)
+	}
+
+	for v := 0; v <= 202; v++ {
+		calls = 0
+		n, comparisons := tree.SearchInstrumented(v)
+		if comparisons != calls {
+			t.Errorf("search for %d reported %d comparisons but made %d", v, comparisons, calls)
+		}
+		if n != tree.Search(v) {
+			t.Errorf("search for %d found a different node", v)
+		}
+
+		// the found node is at the end of the path, a missing value ends
+		// below a leaf
+		if n != nil {
+			if want := depth(n) + 1; comparisons != want {
+				t.Errorf("search for %d want: %d got: %d comparisons", v, want, comparisons)
+			}
+		} else if _, max := tree.LeafDepthRange(); comparisons < 1 || comparisons > max {
+			t.Errorf("search for missing %d made %d comparisons", v, comparisons)
+		}
+	}
+
+	if n, comparisons := New(cmp.Compare[int]).SearchInstrumented(1); n != nil || comparisons != 0 {
+		t.Errorf("empty tree want: nil, 0 got: %v, %d", n, comparisons)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	newTree := func() *RBTree[int] {
+		tree := New(cmp.Compare[int])
+		for i := 1; i <= 10; i++ {
+			tree.Insert(i)
+		}
+		return tree
+	}
+
+	if got := newTree().Verify(); got != nil {
+		t.Errorf("healthy tree reported: %v", got)
+	}
+	if got := New(cmp.Compare[int]).Verify(); got != nil {
+		t.Errorf("empty tree reported: %v", got)
+	}
+
+	type violation struct {
+		kind ViolationKind
+		val  int
+	}
+
+	// see TestNodeSize for the shape
+	tests := []struct {
+		name    string
+		corrupt func(tree *RBTree[int])
+		want    []violation
+	}{
+		{"RedRed", func(tree *RBTree[int]) {
+			// 8 is red so turning 7 red breaks the red rule and removes a
+			// black node from the paths through 7
+			tree.Search(7).setColor(red)
+		}, []violation{{RedRedViolation, 7}, {BlackHeightMismatch, 8}}},
+		{"BlackHeight", func(tree *RBTree[int]) {
+			tree.Search(1).setColor(red)
+		}, []violation{{BlackHeightMismatch, 2}}},
+		{"RootNotBlack", func(tree *RBTree[int]) {
+			tree.root.setColor(red)
+		}, []violation{{RootNotBlack, 4}}},
+		{"ParentPointer", func(tree *RBTree[int]) {
+			tree.Search(7).setParent(tree.root)
+		}, []violation{{BrokenParentPointer, 7}, {BlackHeightMismatch, 8}}},
+		{"RootParent", func(tree *RBTree[int]) {
+			tree.root.setParent(tree.Search(5))
+		}, []violation{{BrokenParentPointer, 4}}},
+		{"Cycle", func(tree *RBTree[int]) {
+			tree.Search(10).right = tree.root
+		}, []violation{{BrokenParentPointer, 4}}},
+		{"ConsistentCycle", func(tree *RBTree[int]) {
+			// the back pointers agree all the way around the loop
+			a := tree.root.left
+			a.right = tree.root
+			tree.root.setParent(a)
+		}, []violation{{BrokenParentPointer, 4}, {Cycle, 4}, {BlackHeightMismatch, 2}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tree := newTree()
+			test.corrupt(tree)
+
+			var got []violation
+			for _, v := range tree.Verify() {
+				got = append(got, violation{v.Kind, v.Node.Value})
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("slices differ:\n%v\n%v", got, test.want)
+			}
+		})
+	}
+
+	v := Violation[int]{Kind: RedRedViolation, Node: &Node[int]{Value: 7}}
+	if got, want := v.String(), "red node with red parent at 7"; got != want {
+		t.Errorf("want: %q got: %q", want, got)
+	}
+}
@@ -0,0 +1,278 @@
+package rbtree
+
+import (
+	"bytes"
+	"cmp"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// TestEdgeCases runs every query and iterator of the tree against an
+// empty and a one element tree, none of them may panic and all of them
+// must agree with the contents.
+func TestEdgeCases(t *testing.T) {
+	for _, vals := range [][]int{nil, {5}} {
+		name := "Empty"
+		if len(vals) > 0 {
+			name = "Singleton"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			newTree := func() *RBTree[int] {
+				tree := New(cmp.Compare[int])
+				for _, v := range vals {
+					tree.Insert(v)
+				}
+				return tree
+			}
+			tree := newTree()
+			single := len(vals) == 1
+			var node *Node[int]
+			if single {
+				node = tree.root
+			}
+
+			t.Run("Lookups", func(t *testing.T) {
+				if tree.Len() != len(vals) {
+					t.Errorf("want: %d got: %d", len(vals), tree.Len())
+				}
+				for _, n := range []*Node[int]{
+					tree.Min(), tree.Max(), tree.Search(5), tree.SearchFirst(5), tree.SearchLast(5),
+					tree.Ceiling(0), tree.Select(0), tree.SelectFromEnd(0),
+				} {
+					if n != node {
+						t.Errorf("want: %v got: %v", node, n)
+					}
+				}
+				if n, _ := tree.SearchInstrumented(5); n != node {
+					t.Errorf("want: %v got: %v", node, n)
+				}
+				for _, n := range []*Node[int]{
+					tree.Search(4), tree.Ceiling(6), tree.Select(-1), tree.Select(1), tree.SelectFromEnd(1),
+					tree.Successor(node), tree.Predecessor(node), tree.Successor(nil), tree.Predecessor(nil),
+				} {
+					if n != nil {
+						t.Errorf("want: nil got: %v", n.Value)
+					}
+				}
+				if tree.Has(5) != single || tree.Has(4) {
+					t.Error("has disagrees with the contents")
+				}
+				if tree.Rank(5) != 0 || tree.Rank(6) != len(vals) || tree.ApproxRank(6) != len(vals) {
+					t.Error("ranks disagree with the contents")
+				}
+				if _, _, hasPrev, hasNext := tree.NeighborsOf(5); hasPrev || hasNext {
+					t.Error("a lone value has no neighbors")
+				}
+				if got := tree.GetGroup(5); !slices.Equal(got, vals) {
+					t.Errorf("slices differ:\n%#v\n%#v", got, vals)
+				}
+				if got := tree.SearchAllSorted([]int{5}); got[0] != node {
+					t.Errorf("want: %v got: %v", node, got[0])
+				}
+				if got := tree.LCA(node, node); got != node {
+					t.Errorf("want: %v got: %v", node, got)
+				}
+				if node != nil && tree.Before(node, node) {
+					t.Error("a node is not before itself")
+				}
+			})
+
+			t.Run("Iterators", func(t *testing.T) {
+				for _, method := range []IterationMethod{InOrder, PreOrder, PostOrder, LevelOrder, LevelOrderSorted} {
+					iterators := []func(func(int) bool){
+						tree.Iterate(method),
+						tree.IterateSnapshot(method),
+						tree.IterateCopy(method, func(v int) int { return v }),
+					}
+					for _, iterate := range iterators {
+						if got := runIterator(iterate); !slices.Equal(got, vals) {
+							t.Errorf("method %d slices differ:\n%#v\n%#v", method, got, vals)
+						}
+					}
+					count := 0
+					tree.NodeIterate(method)(func(*Node[int]) bool {
+						count++
+						return true
+					})
+					if count != len(vals) {
+						t.Errorf("method %d want: %d got: %d", method, len(vals), count)
+					}
+
+					// stopping on the only value must not touch the tree again
+					count = 0
+					tree.Iterate(method)(func(int) bool {
+						count++
+						return false
+					})
+					if count != len(vals) {
+						t.Errorf("method %d did not stop: %d", method, count)
+					}
+				}
+
+				iterators := []func(func(int) bool){
+					tree.Between(0, 10), tree.BetweenReverse(0, 10), tree.RangeHalfOpen(0, 10),
+					tree.IterateFromKey(0, true), tree.IterateFromKey(6, true), tree.IterateLeaves(),
+					tree.IterateSince(0), MapFilter(tree, func(int) bool { return true }, func(v int) int { return v }),
+					MergeK(cmp.Compare[int], tree, New(cmp.Compare[int])),
+				}
+				for i, iterate := range iterators {
+					if got := runIterator(iterate); !slices.Equal(got, vals) {
+						t.Errorf("iterator %d slices differ:\n%#v\n%#v", i, got, vals)
+					}
+				}
+				for i, iterate := range []func(func(int) bool){tree.Between(6, 10), tree.IterateFromKey(6, false)} {
+					if got := runIterator(iterate); len(got) != 0 {
+						t.Errorf("iterator %d should be empty: %#v", i, got)
+					}
+				}
+
+				tree.IteratePairs()(func(a, b int) bool {
+					t.Errorf("unexpected pair %d, %d", a, b)
+					return true
+				})
+				count := 0
+				tree.IterateRanked()(func(rank, v int) bool {
+					count++
+					return true
+				})
+				tree.IterateChunks(2)(func(chunk []int) bool {
+					count += len(chunk)
+					return true
+				})
+				if count != 2*len(vals) {
+					t.Errorf("want: %d got: %d", 2*len(vals), count)
+				}
+
+				if got := tree.IterateAfter(0, 5); !slices.Equal(got, vals) {
+					t.Errorf("slices differ:\n%#v\n%#v", got, vals)
+				}
+				if got := tree.IterateBefore(10, 5); !slices.Equal(got, vals) {
+					t.Errorf("slices differ:\n%#v\n%#v", got, vals)
+				}
+
+				it := tree.Bidir(0)
+				if _, ok := it.Forward(); ok != single {
+					t.Errorf("want: %t got: %t", single, ok)
+				}
+				if _, ok := it.Backward(); ok != single {
+					t.Errorf("want: %t got: %t", single, ok)
+				}
+				c := tree.NewCursor()
+				if _, ok := c.Next(); ok != single {
+					t.Errorf("want: %t got: %t", single, ok)
+				}
+				if _, ok := c.Next(); ok {
+					t.Error("cursor should be past the end")
+				}
+			})
+
+			t.Run("Aggregates", func(t *testing.T) {
+				if got := tree.Flatten(); !slices.Equal(got, vals) {
+					t.Errorf("slices differ:\n%#v\n%#v", got, vals)
+				}
+				partitions := tree.Partition(2)
+				if len(partitions) != 2 || !slices.Equal(partitions[0], vals) || len(partitions[1]) != 0 {
+					t.Errorf("unexpected partitions: %#v", partitions)
+				}
+				rng := rand.New(rand.NewSource(1))
+				if _, ok := tree.Sample(rng); ok != single {
+					t.Errorf("want: %t got: %t", single, ok)
+				}
+				if got := tree.SampleN(3, rng); !slices.Equal(got, vals) {
+					t.Errorf("slices differ:\n%#v\n%#v", got, vals)
+				}
+				if got := tree.UnionCount(tree); got != len(vals) {
+					t.Errorf("want: %d got: %d", len(vals), got)
+				}
+				if got := tree.IntersectionCount(New(cmp.Compare[int])); got != 0 {
+					t.Errorf("want: 0 got: %d", got)
+				}
+				if got := tree.SymmetricDifference(tree); got.Len() != 0 {
+					t.Errorf("want: 0 got: %d", got.Len())
+				}
+				if tree.ContentHash(intHash) == New(cmp.Compare[int]).ContentHash(intHash) == single {
+					t.Error("hash disagrees with the contents")
+				}
+				if min, max := tree.LeafDepthRange(); min != len(vals) || max != len(vals) {
+					t.Errorf("want: %d, %d got: %d, %d", len(vals), len(vals), min, max)
+				}
+				if tree.TombstoneRatio() != 0 || tree.LastSeq() != uint64(len(vals)) {
+					t.Error("bookkeeping disagrees with the contents")
+				}
+			})
+
+			t.Run("Diagnostics", func(t *testing.T) {
+				if !tree.IsSorted() || tree.CheckIntegrity() != nil || tree.Verify() != nil {
+					t.Error("tree should be valid")
+				}
+				if repaired, err := tree.ValidateAndRepair(); repaired || err != nil {
+					t.Errorf("want: false, nil got: %t, %v", repaired, err)
+				}
+				_ = tree.String()
+				_ = tree.PathTo(5)
+				_ = tree.PathTo(4)
+				if got, want := tree.Bracketed(), map[bool]string{false: "", true: "5B"}[single]; got != want {
+					t.Errorf("want: %q got: %q", want, got)
+				}
+				if got := tree.Shape(); len(got) != len(vals) {
+					t.Errorf("want: %d got: %d", len(vals), len(got))
+				}
+				var buf bytes.Buffer
+				if err := tree.WriteTo(&buf, encodeInt); err != nil {
+					t.Fatal(err)
+				}
+				out, err := ReadFrom(&buf, cmp.Compare[int], decodeInt)
+				if err != nil || !slices.Equal(out.Flatten(), vals) {
+					t.Errorf("round trip failed: %v", err)
+				}
+			})
+
+			t.Run("Mutations", func(t *testing.T) {
+				tree := newTree()
+				tree.Mirror()
+				tree.Mirror()
+				tree.Vacuum()
+				tree.Reindex()
+				tree.Refresh(nil)
+				if got := tree.Flatten(); !slices.Equal(got, vals) {
+					t.Errorf("slices differ:\n%#v\n%#v", got, vals)
+				}
+				if tree.Delete(4) || tree.DeleteFirst(4) || tree.DeleteLast(4) || tree.DeleteNode(nil) {
+					t.Error("deleted a missing value")
+				}
+				if got := tree.DeleteRankRange(0, 10); got != len(vals) {
+					t.Errorf("want: %d got: %d", len(vals), got)
+				}
+				if got := tree.ExtractMinN(3); len(got) != 0 {
+					t.Errorf("expected nothing to extract: %#v", got)
+				}
+
+				tree = newTree()
+				if got := tree.ExtractMinN(3); !slices.Equal(got, vals) {
+					t.Errorf("slices differ:\n%#v\n%#v", got, vals)
+				}
+				tree = newTree()
+				if got := runIterator(tree.Drain()); !slices.Equal(got, vals) || tree.Len() != 0 {
+					t.Errorf("slices differ:\n%#v\n%#v", got, vals)
+				}
+				tree = newTree()
+				if got := tree.Subtract(newTree()); got != len(vals) || tree.Len() != 0 {
+					t.Errorf("want: %d got: %d", len(vals), got)
+				}
+				tree = newTree()
+				if got := tree.Retain(newTree()); got != 0 || tree.Len() != len(vals) {
+					t.Errorf("want: 0 got: %d", got)
+				}
+				if deleted, err := tree.TryDelete(5); deleted != single || err != nil {
+					t.Errorf("want: %t, nil got: %t, %v", single, deleted, err)
+				}
+				tree.Rebuild(nil)
+				if tree.Len() != 0 || tree.Min() != nil {
+					t.Error("rebuild with nothing should empty the tree")
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package rbtree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+)
+
+// encodingVersion is written in the header by WriteTo.
+const encodingVersion = 1
+
+// ErrEncoding is wrapped by the errors ReadFrom returns for malformed
+// input.
+var ErrEncoding = errors.New("invalid tree encoding")
+
+// WriteTo streams the values of the tree to w in ascending order using
+// encode for each value, without buffering the tree in memory. The values
+// are preceded by a small header holding a version byte and the number of
+// values as a big endian uint64. Lazily deleted values are not written.
+//
+// Note that this is not io.WriterTo. Read the tree back with ReadFrom.
+func (r *RBTree[T]) WriteTo(w io.Writer, encode func(io.Writer, T) error) error {
+	var header [9]byte
+	header[0] = encodingVersion
+	binary.BigEndian.PutUint64(header[1:], uint64(r.Len()))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+
+	var err error
+	r.Iterate(InOrder)(func(v T) bool {
+		err = encode(w, v)
+		return err == nil
+	})
+	return err
+}
+
+// ReadFrom reads a tree written by WriteTo from rd using decode for each
+// value, each call must consume exactly the bytes of one value. The tree
+// is bulk-built in O(n) as the values are read so they are never held in
+// an intermediate slice. The values must be strictly ascending according
+// to compare, otherwise an error wrapping ErrEncoding is returned.
+func ReadFrom[T any](rd io.Reader, compare func(a, b T) int, decode func(io.Reader) (T, error)) (*RBTree[T], error) {
+	var header [9]byte
+	if _, err := io.ReadFull(rd, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != encodingVersion {
+		return nil, fmt.Errorf("%w: unknown version %d", ErrEncoding, header[0])
+	}
+	count := binary.BigEndian.Uint64(header[1:])
+	if count > math.MaxInt {
+		return nil, fmt.Errorf("%w: count %d is too large", ErrEncoding, count)
+	}
+
+	r := New(compare)
+	s := streamBuilder[T]{tree: r, rd: rd, decode: decode}
+	root, err := s.link(int(count))
+	if err != nil {
+		return nil, err
+	}
+	r.root = root
+	return r, nil
+}
+
+// streamBuilder builds a tree in the same shape as link while decoding
+// the values of the nodes in order.
+type streamBuilder[T any] struct {
+	tree   *RBTree[T]
+	rd     io.Reader
+	decode func(io.Reader) (T, error)
+	// prev is the last node decoded, used to check the order
+	prev *Node[T]
+	read uint64
+}
+
+func (s *streamBuilder[T]) link(count int) (*Node[T], error) {
+	if count == 0 {
+		return s.tree.nil, nil
+	}
+
+	redDepth := bits.Len(uint(count)) - 1
+	if redDepth == 0 {
+		// a lone root must be black
+		redDepth = -1
+	}
+
+	root, err := s.linkSubtree(count, 0, redDepth)
+	if err != nil {
+		return nil, err
+	}
+	root.setParent(nil)
+	return root, nil
+}
+
+func (s *streamBuilder[T]) linkSubtree(count, depth, redDepth int) (*Node[T], error) {
+	r := s.tree
+	if count == 0 {
+		return r.nil, nil
+	}
+
+	// the left subtree holds the values before the middle one
+	mid := count / 2
+	left, err := s.linkSubtree(mid, depth+1, redDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := s.decode(s.rd)
+	if err != nil {
+		return nil, err
+	}
+	if s.prev != nil && r.compare(s.prev.Value, v) >= 0 {
+		return nil, fmt.Errorf("%w: value %d is out of order", ErrEncoding, s.read)
+	}
+	s.read++
+
+	r.seq++
+	n := r.allocNode()
+	*n = Node[T]{Value: v, seq: r.seq, size: count, left: left}
+	if depth == redDepth {
+		n.setColor(red)
+	}
+	if left != r.nil {
+		left.setParent(n)
+	}
+	s.prev = n
+
+	if n.right, err = s.linkSubtree(count-mid-1, depth+1, redDepth); err != nil {
+		return nil, err
+	}
+	if n.right != r.nil {
+		n.right.setParent(n)
+	}
+	return n, nil
+}
+
+// SpillToDisk writes the tree to the file at path in the format of
+// WriteTo, creating or truncating it. Writes are buffered but the tree is
+// streamed so memory use does not grow with the size of the tree.
+func (r *RBTree[T]) SpillToDisk(path string, encode func(io.Writer, T) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	if err := r.WriteTo(w, encode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadFromDisk reads a tree written by SpillToDisk from the file at path,
+// see ReadFrom.
+func LoadFromDisk[T any](path string, compare func(a, b T) int, decode func(io.Reader) (T, error)) (*RBTree[T], error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadFrom(bufio.NewReader(f), compare, decode)
+}
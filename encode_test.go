@@ -0,0 +1,143 @@
+package rbtree
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func encodeInt(w io.Writer, v int) error {
+	return binary.Write(w, binary.BigEndian, int64(v))
+}
+
+func decodeInt(r io.Reader) (int, error) {
+	var v int64
+	err := binary.Read(r, binary.BigEndian, &v)
+	return int(v), err
+}
+
+func TestWriteToReadFrom(t *testing.T) {
+	tree := New(cmp.Compare[int], WithLazyDelete[int]())
+	for i := 0; i < 100000; i++ {
+		tree.Insert(i * 3)
+	}
+	tree.Delete(42)
+
+	var buf bytes.Buffer
+	if err := tree.WriteTo(&buf, encodeInt); err != nil {
+		t.Fatal(err)
+	}
+	if want := 9 + 8*tree.Len(); buf.Len() != want {
+		t.Errorf("want: %d bytes got: %d", want, buf.Len())
+	}
+
+	out, err := ReadFrom(&buf, cmp.Compare[int], decodeInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	isRedBlackTree(t, out, out.root)
+	if got, want := out.Flatten(), tree.Flatten(); !slices.Equal(got, want) {
+		t.Error("round tripped tree differs")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("%d bytes left unread", buf.Len())
+	}
+
+	t.Run("Streaming", func(t *testing.T) {
+		// the encoder reuses one buffer so any allocation per value would
+		// have to come from WriteTo itself
+		var scratch [8]byte
+		encode := func(w io.Writer, v int) error {
+			binary.BigEndian.PutUint64(scratch[:], uint64(v))
+			_, err := w.Write(scratch[:])
+			return err
+		}
+		allocs := testing.AllocsPerRun(5, func() {
+			if err := tree.WriteTo(io.Discard, encode); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if allocs > 10 {
+			t.Errorf("writing %d values allocated %v times", tree.Len(), allocs)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := New(cmp.Compare[int]).WriteTo(&buf, encodeInt); err != nil {
+			t.Fatal(err)
+		}
+		out, err := ReadFrom(&buf, cmp.Compare[int], decodeInt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("want: 0 got: %d", out.Len())
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		var buf bytes.Buffer
+		small := New(cmp.Compare[int])
+		small.Insert(1)
+		small.Insert(2)
+		if err := small.WriteTo(&buf, encodeInt); err != nil {
+			t.Fatal(err)
+		}
+		encoded := buf.Bytes()
+
+		version := slices.Clone(encoded)
+		version[0] = 9
+		if _, err := ReadFrom(bytes.NewReader(version), cmp.Compare[int], decodeInt); !errors.Is(err, ErrEncoding) {
+			t.Errorf("expected ErrEncoding, got: %v", err)
+		}
+
+		reversed := New(func(a, b int) int { return cmp.Compare(b, a) })
+		if _, err := ReadFrom(bytes.NewReader(encoded), reversed.compare, decodeInt); !errors.Is(err, ErrEncoding) {
+			t.Errorf("expected ErrEncoding, got: %v", err)
+		}
+
+		if _, err := ReadFrom(bytes.NewReader(encoded[:len(encoded)-1]), cmp.Compare[int], decodeInt); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected io.ErrUnexpectedEOF, got: %v", err)
+		}
+	})
+}
+
+func TestSpillToDisk(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for _, v := range rand.Perm(10000) {
+		tree.Insert(v)
+	}
+
+	path := filepath.Join(t.TempDir(), "tree.bin")
+	if err := tree.SpillToDisk(path, encodeInt); err != nil {
+		t.Fatal(err)
+	}
+	out, err := LoadFromDisk(path, cmp.Compare[int], decodeInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	isRedBlackTree(t, out, out.root)
+	if got, want := out.Flatten(), tree.Flatten(); !slices.Equal(got, want) {
+		t.Error("reloaded tree differs")
+	}
+
+	// loading a partial file fails instead of returning a partial tree
+	if err := os.Truncate(path, 9+8*5000+3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFromDisk(path, cmp.Compare[int], decodeInt); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got: %v", err)
+	}
+
+	if _, err := LoadFromDisk(filepath.Join(t.TempDir(), "missing"), cmp.Compare[int], decodeInt); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist, got: %v", err)
+	}
+}
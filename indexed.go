@@ -0,0 +1,88 @@
+package rbtree
+
+// IndexedTree is a red black tree paired with a map index on a key
+// projected from each value. The tree provides ordered access while the
+// map provides O(1) lookups by key, both are kept in sync by the
+// IndexedTree's methods.
+//
+// The key projection should be consistent with the comparator in the
+// sense that two values with the same key must compare equal, otherwise
+// Insert will panic as the index cannot hold both.
+type IndexedTree[K comparable, T any] struct {
+	tree  *RBTree[T]
+	key   func(T) K
+	index map[K]*Node[T]
+}
+
+// NewIndexed constructs an IndexedTree ordered by compare and indexed by
+// the key projection.
+func NewIndexed[K comparable, T any](compare func(a, b T) int, key func(T) K) *IndexedTree[K, T] {
+	return &IndexedTree[K, T]{
+		tree:  New(compare),
+		key:   key,
+		index: make(map[K]*Node[T]),
+	}
+}
+
+// Insert val into both the tree and the index. Panics if the key or
+// the value is already present.
+func (i *IndexedTree[K, T]) Insert(val T) *Node[T] {
+	k := i.key(val)
+	if _, ok := i.index[k]; ok {
+		panic("duplicate key")
+	}
+
+	n := i.tree.Insert(val)
+	i.index[k] = n
+	return n
+}
+
+// Get looks up a node by its key, returns nil if not found.
+func (i *IndexedTree[K, T]) Get(k K) *Node[T] {
+	return i.index[k]
+}
+
+// Has reports whether a value with the key is present.
+func (i *IndexedTree[K, T]) Has(k K) bool {
+	_, ok := i.index[k]
+	return ok
+}
+
+// Delete the value comparing equal to val from both the tree and the
+// index.
+func (i *IndexedTree[K, T]) Delete(val T) bool {
+	return i.DeleteNode(i.tree.Search(val))
+}
+
+// DeleteKey deletes the value with the given key from both the tree and
+// the index.
+func (i *IndexedTree[K, T]) DeleteKey(k K) bool {
+	return i.DeleteNode(i.index[k])
+}
+
+// DeleteNode deletes the node from both the tree and the index.
+//
+// The key is always recomputed from the stored value rather than from a
+// caller provided one, this way the index entry is found even if the
+// caller's copy differs in fields the comparator ignores. Deleting never
+// moves values between nodes so the index entries for other values
+// remain valid.
+func (i *IndexedTree[K, T]) DeleteNode(n *Node[T]) bool {
+	if n == nil {
+		return false
+	}
+
+	delete(i.index, i.key(n.Value))
+	return i.tree.DeleteNode(n)
+}
+
+// Len returns the number of values stored.
+func (i *IndexedTree[K, T]) Len() int {
+	return len(i.index)
+}
+
+// Iterate over the values with the desired iteration method, see
+// RBTree.Iterate.
+func (i *IndexedTree[K, T]) Iterate(method IterationMethod) func(func(T) bool) {
+	return i.tree.Iterate(method)
+}
@@ -0,0 +1,104 @@
+package rbtree
+
+import (
+	"cmp"
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+type indexedUser struct {
+	id   string
+	rank int
+}
+
+func TestIndexedTree(t *testing.T) {
+	tree := NewIndexed(
+		func(a, b indexedUser) int { return cmp.Compare(a.rank, b.rank) },
+		func(u indexedUser) string { return u.id },
+	)
+
+	ranks := rand.Perm(50)
+	for _, r := range ranks {
+		tree.Insert(indexedUser{id: strconv.Itoa(r), rank: r})
+		checkIndexedTree(t, tree)
+	}
+
+	n := tree.Get("25")
+	if n == nil || n.Value.rank != 25 {
+		t.Fatalf("lookup failed: %#v", n)
+	}
+
+	rand.Shuffle(len(ranks), func(i, j int) { ranks[i], ranks[j] = ranks[j], ranks[i] })
+	for i, r := range ranks {
+		var ok bool
+		switch i % 3 {
+		case 0:
+			ok = tree.DeleteKey(strconv.Itoa(r))
+		case 1:
+			// id is ignored by the comparator, the stored id must be used
+			ok = tree.Delete(indexedUser{rank: r})
+		case 2:
+			ok = tree.DeleteNode(tree.Get(strconv.Itoa(r)))
+		}
+		if !ok {
+			t.Errorf("failed to delete: %d", r)
+		}
+		if tree.Has(strconv.Itoa(r)) {
+			t.Errorf("still indexed: %d", r)
+		}
+		checkIndexedTree(t, tree)
+	}
+
+	if tree.Len() != 0 {
+		t.Errorf("want empty, got: %d", tree.Len())
+	}
+	if tree.DeleteKey("nope") {
+		t.Error("deleted missing key")
+	}
+}
+
+func TestIndexedTreeDuplicateKey(t *testing.T) {
+	tree := NewIndexed(
+		func(a, b indexedUser) int { return cmp.Compare(a.rank, b.rank) },
+		func(u indexedUser) string { return u.id },
+	)
+	tree.Insert(indexedUser{id: "a", rank: 1})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+		checkIndexedTree(t, tree)
+	}()
+	tree.Insert(indexedUser{id: "a", rank: 2})
+}
+
+// checkIndexedTree ensures every value in the tree is indexed by its own
+// node and vice versa.
+func checkIndexedTree(t *testing.T, tree *IndexedTree[string, indexedUser]) {
+	t.Helper()
+
+	isRedBlackTree(t, tree.tree, tree.tree.root)
+
+	var inTree []string
+	iterator := inOrderIter[indexedUser]{tree: tree.tree}
+	iterator.nodes(func(n *Node[indexedUser]) bool {
+		inTree = append(inTree, n.Value.id)
+		if tree.index[n.Value.id] != n {
+			t.Errorf("index for %s points at the wrong node", n.Value.id)
+		}
+		return true
+	})
+
+	var inIndex []string
+	for k := range tree.index {
+		inIndex = append(inIndex, k)
+	}
+	slices.Sort(inTree)
+	slices.Sort(inIndex)
+	if !slices.Equal(inTree, inIndex) {
+		t.Errorf("tree and index differ:\n%#v\n%#v", inTree, inIndex)
+	}
+}
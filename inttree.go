@@ -0,0 +1,358 @@
+package rbtree
+
+import "math"
+
+// IntTree is a red black tree of ints that keeps all of its nodes in a
+// single slice and links them with int32 indices instead of pointers.
+// Nodes are small and packed together which is much friendlier to the
+// cache than the generic tree when holding millions of integers, and the
+// arena can be walked or copied without chasing pointers.
+//
+// IntTree mirrors the value based API of RBTree but hands out no nodes
+// since their indices are reused once deleted.
+type IntTree struct {
+	// nodes[0] is the sentinel
+	nodes []intNode
+	free  []int32
+	root  int32
+}
+
+type intNode struct {
+	parent int32
+	left   int32
+	right  int32
+	color  color
+	value  int
+}
+
+// NewIntTree constructs an IntTree with room for capacity values before
+// the arena has to grow.
+func NewIntTree(capacity int) *IntTree {
+	nodes := make([]intNode, 1, capacity+1)
+	nodes[0].color = black
+	return &IntTree{nodes: nodes}
+}
+
+// Len returns the number of values in the tree.
+func (t *IntTree) Len() int {
+	return len(t.nodes) - 1 - len(t.free)
+}
+
+// Insert val into the tree, panics if val is already present.
+func (t *IntTree) Insert(val int) {
+	var parent int32
+	current := t.root
+	for current != 0 {
+		parent = current
+		n := &t.nodes[current]
+		if val < n.value {
+			current = n.left
+		} else if val > n.value {
+			current = n.right
+		} else {
+			panic("duplicate value")
+		}
+	}
+
+	z := t.alloc(val)
+	t.nodes[z].parent = parent
+	if parent == 0 {
+		t.root = z
+	} else if val < t.nodes[parent].value {
+		t.nodes[parent].left = z
+	} else {
+		t.nodes[parent].right = z
+	}
+
+	t.insertFixup(z)
+}
+
+// alloc returns the index of a fresh red node holding val, reusing the
+// slots of deleted nodes first.
+func (t *IntTree) alloc(val int) int32 {
+	node := intNode{color: red, value: val}
+	if len(t.free) > 0 {
+		i := t.free[len(t.free)-1]
+		t.free = t.free[:len(t.free)-1]
+		t.nodes[i] = node
+		return i
+	}
+
+	if len(t.nodes) > math.MaxInt32 {
+		panic("tree is full")
+	}
+	t.nodes = append(t.nodes, node)
+	return int32(len(t.nodes) - 1)
+}
+
+func (t *IntTree) insertFixup(z int32) {
+	n := t.nodes
+	for n[n[z].parent].color == red {
+		p := n[z].parent
+		g := n[p].parent
+		if p == n[g].left {
+			uncle := n[g].right
+			if n[uncle].color == red {
+				n[p].color = black
+				n[uncle].color = black
+				n[g].color = red
+				z = g
+				continue
+			}
+			if z == n[p].right {
+				z = p
+				t.rotateLeft(z)
+				p = n[z].parent
+			}
+			n[p].color = black
+			n[g].color = red
+			t.rotateRight(g)
+		} else {
+			uncle := n[g].left
+			if n[uncle].color == red {
+				n[p].color = black
+				n[uncle].color = black
+				n[g].color = red
+				z = g
+				continue
+			}
+			if z == n[p].left {
+				z = p
+				t.rotateRight(z)
+				p = n[z].parent
+			}
+			n[p].color = black
+			n[g].color = red
+			t.rotateLeft(g)
+		}
+	}
+	n[t.root].color = black
+}
+
+// Delete val from the tree, returns false if it was not present.
+func (t *IntTree) Delete(val int) bool {
+	z := t.search(val)
+	if z == 0 {
+		return false
+	}
+
+	n := t.nodes
+	y, originalColor := z, n[z].color
+	var x int32
+	if n[z].left == 0 {
+		x = n[z].right
+		t.transplant(z, x)
+	} else if n[z].right == 0 {
+		x = n[z].left
+		t.transplant(z, x)
+	} else {
+		y = n[z].right
+		for n[y].left != 0 {
+			y = n[y].left
+		}
+		originalColor = n[y].color
+		x = n[y].right
+		if n[y].parent == z {
+			n[x].parent = y
+		} else {
+			t.transplant(y, x)
+			n[y].right = n[z].right
+			n[n[y].right].parent = y
+		}
+		t.transplant(z, y)
+		n[y].left = n[z].left
+		n[n[y].left].parent = y
+		n[y].color = n[z].color
+	}
+
+	if originalColor == black {
+		t.deleteFixup(x)
+	}
+
+	n[0].parent = 0
+	t.free = append(t.free, z)
+	return true
+}
+
+func (t *IntTree) transplant(u, v int32) {
+	n := t.nodes
+	p := n[u].parent
+	if p == 0 {
+		t.root = v
+	} else if u == n[p].left {
+		n[p].left = v
+	} else {
+		n[p].right = v
+	}
+	n[v].parent = p
+}
+
+func (t *IntTree) deleteFixup(x int32) {
+	n := t.nodes
+	for x != t.root && n[x].color == black {
+		p := n[x].parent
+		if x == n[p].left {
+			w := n[p].right
+			if n[w].color == red {
+				n[w].color = black
+				n[p].color = red
+				t.rotateLeft(p)
+				w = n[p].right
+			}
+			if n[n[w].left].color == black && n[n[w].right].color == black {
+				n[w].color = red
+				x = p
+				continue
+			}
+			if n[n[w].right].color == black {
+				n[n[w].left].color = black
+				n[w].color = red
+				t.rotateRight(w)
+				w = n[p].right
+			}
+			n[w].color = n[p].color
+			n[p].color = black
+			n[n[w].right].color = black
+			t.rotateLeft(p)
+			x = t.root
+		} else {
+			w := n[p].left
+			if n[w].color == red {
+				n[w].color = black
+				n[p].color = red
+				t.rotateRight(p)
+				w = n[p].left
+			}
+			if n[n[w].left].color == black && n[n[w].right].color == black {
+				n[w].color = red
+				x = p
+				continue
+			}
+			if n[n[w].left].color == black {
+				n[n[w].right].color = black
+				n[w].color = red
+				t.rotateLeft(w)
+				w = n[p].left
+			}
+			n[w].color = n[p].color
+			n[p].color = black
+			n[n[w].left].color = black
+			t.rotateRight(p)
+			x = t.root
+		}
+	}
+	n[x].color = black
+}
+
+func (t *IntTree) rotateLeft(x int32) {
+	n := t.nodes
+	y := n[x].right
+	n[x].right = n[y].left
+	if n[y].left != 0 {
+		n[n[y].left].parent = x
+	}
+	t.replaceChild(x, y)
+	n[y].left = x
+	n[x].parent = y
+}
+
+func (t *IntTree) rotateRight(x int32) {
+	n := t.nodes
+	y := n[x].left
+	n[x].left = n[y].right
+	if n[y].right != 0 {
+		n[n[y].right].parent = x
+	}
+	t.replaceChild(x, y)
+	n[y].right = x
+	n[x].parent = y
+}
+
+// replaceChild puts y in the place of x underneath the parent of x.
+func (t *IntTree) replaceChild(x, y int32) {
+	n := t.nodes
+	p := n[x].parent
+	n[y].parent = p
+	if p == 0 {
+		t.root = y
+	} else if x == n[p].left {
+		n[p].left = y
+	} else {
+		n[p].right = y
+	}
+}
+
+func (t *IntTree) search(val int) int32 {
+	current := t.root
+	for current != 0 {
+		n := &t.nodes[current]
+		if val < n.value {
+			current = n.left
+		} else if val > n.value {
+			current = n.right
+		} else {
+			return current
+		}
+	}
+	return 0
+}
+
+// Has checks if val is in the tree.
+func (t *IntTree) Has(val int) bool {
+	return t.search(val) != 0
+}
+
+// Min returns the smallest value, ok is false if the tree is empty.
+func (t *IntTree) Min() (min int, ok bool) {
+	if t.root == 0 {
+		return 0, false
+	}
+	i := t.root
+	for t.nodes[i].left != 0 {
+		i = t.nodes[i].left
+	}
+	return t.nodes[i].value, true
+}
+
+// Max returns the largest value, ok is false if the tree is empty.
+func (t *IntTree) Max() (max int, ok bool) {
+	if t.root == 0 {
+		return 0, false
+	}
+	i := t.root
+	for t.nodes[i].right != 0 {
+		i = t.nodes[i].right
+	}
+	return t.nodes[i].value, true
+}
+
+// Iterate over the values in ascending order. See RBTree.Iterate.
+func (t *IntTree) Iterate() func(func(int) bool) {
+	return func(yield func(int) bool) {
+		var stack []int32
+		current := t.root
+		for current != 0 || len(stack) > 0 {
+			for current != 0 {
+				stack = append(stack, current)
+				current = t.nodes[current].left
+			}
+			current = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			if !yield(t.nodes[current].value) {
+				return
+			}
+			current = t.nodes[current].right
+		}
+	}
+}
+
+// Flatten returns the values of the tree as a sorted slice.
+func (t *IntTree) Flatten() []int {
+	out := make([]int, 0, t.Len())
+	t.Iterate()(func(v int) bool {
+		out = append(out, v)
+		return true
+	})
+	return out
+}
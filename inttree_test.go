@@ -0,0 +1,164 @@
+package rbtree
+
+import (
+	"cmp"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// isRedBlackIntTree checks the links and red black properties of the
+// arena, returning the black height of i.
+func isRedBlackIntTree(t *testing.T, tree *IntTree, i int32) int {
+	t.Helper()
+
+	if i == 0 {
+		return 1
+	}
+	n := tree.nodes[i]
+	for _, child := range []int32{n.left, n.right} {
+		if child == 0 {
+			continue
+		}
+		if tree.nodes[child].parent != i {
+			t.Fatalf("parent pointer of %d does not point at %d", tree.nodes[child].value, n.value)
+		}
+		if n.color == red && tree.nodes[child].color == red {
+			t.Fatalf("red node %d has a red child", n.value)
+		}
+	}
+
+	left, right := isRedBlackIntTree(t, tree, n.left), isRedBlackIntTree(t, tree, n.right)
+	if left != right {
+		t.Fatalf("black height inconsistent at %d", n.value)
+	}
+	if n.color == black {
+		left++
+	}
+	return left
+}
+
+func TestIntTree(t *testing.T) {
+	tree := NewIntTree(0)
+	if _, ok := tree.Min(); ok {
+		t.Error("empty tree should have no min")
+	}
+	if _, ok := tree.Max(); ok {
+		t.Error("empty tree should have no max")
+	}
+
+	reference := New(cmp.Compare[int])
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		v := rng.Intn(500)
+		if rng.Intn(3) == 0 {
+			if got, want := tree.Delete(v), reference.Delete(v); got != want {
+				t.Fatalf("delete %d want: %t got: %t", v, want, got)
+			}
+		} else if !reference.Has(v) {
+			tree.Insert(v)
+			reference.Insert(v)
+		}
+		if tree.nodes[tree.root].color != black {
+			t.Fatal("root must be black")
+		}
+		isRedBlackIntTree(t, tree, tree.root)
+	}
+
+	if out, want := tree.Flatten(), reference.Flatten(); !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+	if tree.Len() != reference.Len() {
+		t.Errorf("want: %d got: %d", reference.Len(), tree.Len())
+	}
+	for v := 0; v < 500; v++ {
+		if tree.Has(v) != reference.Has(v) {
+			t.Errorf("has %d want: %t", v, reference.Has(v))
+		}
+	}
+	if min, _ := tree.Min(); min != reference.Min().Value {
+		t.Errorf("want: %d got: %d", reference.Min().Value, min)
+	}
+	if max, _ := tree.Max(); max != reference.Max().Value {
+		t.Errorf("want: %d got: %d", reference.Max().Value, max)
+	}
+
+	// deleted slots are reused instead of growing the arena
+	arena := len(tree.nodes)
+	for _, v := range reference.Flatten() {
+		tree.Delete(v)
+	}
+	for v := 0; v < arena-1; v++ {
+		tree.Insert(v)
+	}
+	if len(tree.nodes) != arena {
+		t.Errorf("arena grew from %d to %d", arena, len(tree.nodes))
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a duplicate panic")
+		}
+	}()
+	tree.Insert(0)
+}
+
+// BenchmarkIntTree compares the arena backed IntTree to the generic tree
+// holding ints.
+func BenchmarkIntTree(b *testing.B) {
+	const size = 1000000
+	vals := rand.New(rand.NewSource(1)).Perm(size)
+
+	b.Run("InsertGeneric", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tree := New(cmp.Compare[int])
+			for _, v := range vals {
+				tree.Insert(v)
+			}
+		}
+	})
+	b.Run("InsertArena", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tree := NewIntTree(size)
+			for _, v := range vals {
+				tree.Insert(v)
+			}
+		}
+	})
+
+	generic, arena := New(cmp.Compare[int]), NewIntTree(size)
+	for _, v := range vals {
+		generic.Insert(v)
+		arena.Insert(v)
+	}
+	b.Run("SearchGeneric", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			generic.Has(vals[i%size])
+		}
+	})
+	b.Run("SearchArena", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			arena.Has(vals[i%size])
+		}
+	})
+	b.Run("IterateGeneric", func(b *testing.B) {
+		sum := 0
+		for i := 0; i < b.N; i++ {
+			generic.Iterate(InOrder)(func(v int) bool {
+				sum += v
+				return true
+			})
+		}
+	})
+	b.Run("IterateArena", func(b *testing.B) {
+		sum := 0
+		for i := 0; i < b.N; i++ {
+			arena.Iterate()(func(v int) bool {
+				sum += v
+				return true
+			})
+		}
+	})
+}
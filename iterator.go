@@ -1,5 +1,10 @@
 package rbtree
 
+import (
+	"math/bits"
+	"slices"
+)
+
 type IterationMethod int
 
 const (
@@ -7,6 +12,11 @@ const (
 	PreOrder
 	PostOrder
 	LevelOrder
+	// LevelOrderSorted visits the tree level by level like LevelOrder but
+	// sorts the nodes of each level by value before yielding them. In a
+	// correctly ordered tree this is the same as LevelOrder, after values
+	// were mutated out of order it shows where they ended up by depth.
+	LevelOrderSorted
 )
 
 // Iterate over the collection with the desired iteration method.
@@ -15,6 +25,13 @@ const (
 // GOEXPERIMENT=rangefunc iter.Seq[V any] iterator proposal.
 // This means it can be used with the range built-in if
 // the environment variable is set.
+//
+// Values are yielded as shallow copies, if T contains slices, maps
+// or pointers then mutating through them will mutate the values
+// stored in the tree. See IterateCopy.
+//
+// Copying also means every step pays for the size of T, for large
+// structs NodeIterate avoids the copies (see BenchmarkIterateLargeValues).
 func (r *RBTree[T]) Iterate(method IterationMethod) func(func(T) bool) {
 	switch method {
 	case InOrder:
@@ -29,11 +46,91 @@ func (r *RBTree[T]) Iterate(method IterationMethod) func(func(T) bool) {
 	case LevelOrder:
 		iterator := levelOrderIter[T]{tree: r}
 		return iterator.Iterate
+	case LevelOrderSorted:
+		iterator := sortedLevelOrderIter[T]{tree: r}
+		return iterator.Iterate
 	default:
 		panic("unknown iteration method")
 	}
 }
 
+// NodeIterate is like Iterate but yields the nodes themselves instead of
+// copies of their values. The nodes must not be deleted or have their
+// values modified in a way that changes their order during iteration.
+func (r *RBTree[T]) NodeIterate(method IterationMethod) func(func(*Node[T]) bool) {
+	var nodes func(func(*Node[T]) bool)
+	switch method {
+	case InOrder:
+		iterator := inOrderIter[T]{tree: r}
+		nodes = iterator.nodes
+	case PreOrder:
+		iterator := preOrderIter[T]{tree: r}
+		nodes = iterator.nodes
+	case PostOrder:
+		iterator := postOrderIter[T]{tree: r}
+		nodes = iterator.nodes
+	case LevelOrder:
+		iterator := levelOrderIter[T]{tree: r}
+		nodes = iterator.nodes
+	case LevelOrderSorted:
+		iterator := sortedLevelOrderIter[T]{tree: r}
+		nodes = iterator.nodes
+	default:
+		panic("unknown iteration method")
+	}
+
+	return func(yield func(*Node[T]) bool) {
+		nodes(func(n *Node[T]) bool {
+			return n.isTombstone() || yield(n)
+		})
+	}
+}
+
+// IterateCopy is like Iterate but runs each value through clone before
+// yielding it. With a clone function that deep copies T the caller is
+// free to mutate the yielded values without affecting the tree.
+func (r *RBTree[T]) IterateCopy(method IterationMethod, clone func(T) T) func(func(T) bool) {
+	iterate := r.Iterate(method)
+	return func(yield func(T) bool) {
+		iterate(func(v T) bool {
+			return yield(clone(v))
+		})
+	}
+}
+
+// IterateSnapshot is like Iterate but collects the values when it's called
+// and returns an iterator over that copy. The snapshot can be iterated
+// any number of times and is unaffected by later changes to the tree,
+// including ones made while iterating it, at the cost of O(n) memory.
+//
+// The iterators returned by Iterate walk the live tree instead, inserting
+// or deleting during such a walk may skip or repeat values.
+func (r *RBTree[T]) IterateSnapshot(method IterationMethod) func(func(T) bool) {
+	values := make([]T, 0, r.Len())
+	r.Iterate(method)(func(v T) bool {
+		values = append(values, v)
+		return true
+	})
+
+	return func(yield func(T) bool) {
+		for _, v := range values {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// stackHint returns the capacity to preallocate for the stack of a
+// depth first walk, or 0 unless WithIteratorPrealloc is set. A red black
+// tree of n nodes is never higher than 2*log2(n+1).
+func (r *RBTree[T]) stackHint() int {
+	if !r.preallocIter {
+		return 0
+	}
+	return 2 * bits.Len(uint(r.root.size))
+}
+
 type inOrderIter[T any] struct {
 	tree  *RBTree[T]
 	stack []*Node[T]
@@ -55,7 +152,23 @@ type levelOrderIter[T any] struct {
 	queue []*Node[T]
 }
 
+type sortedLevelOrderIter[T any] struct {
+	tree  *RBTree[T]
+	level []*Node[T]
+	next  []*Node[T]
+}
+
 func (i *inOrderIter[T]) Iterate(yield func(T) bool) {
+	i.nodes(func(n *Node[T]) bool {
+		return n.isTombstone() || yield(n.Value)
+	})
+}
+
+func (i *inOrderIter[T]) nodes(yield func(*Node[T]) bool) {
+	i.stack = i.stack[:0]
+	if cap(i.stack) == 0 {
+		i.stack = make([]*Node[T], 0, i.tree.stackHint())
+	}
 	current := i.tree.root
 
 	for current != i.tree.nil || len(i.stack) > 0 {
@@ -63,10 +176,12 @@ func (i *inOrderIter[T]) Iterate(yield func(T) bool) {
 			i.stack = append(i.stack, current)
 			current = current.left
 		}
+		// the stack can't be empty here, either it was non-empty before
+		// or current was a real node that was just pushed
 		current = i.stack[len(i.stack)-1]
 		i.stack = i.stack[:len(i.stack)-1]
 
-		if !yield(current.Value) {
+		if !yield(current) {
 			return
 		}
 
@@ -75,16 +190,26 @@ func (i *inOrderIter[T]) Iterate(yield func(T) bool) {
 }
 
 func (i *preOrderIter[T]) Iterate(yield func(T) bool) {
+	i.nodes(func(n *Node[T]) bool {
+		return n.isTombstone() || yield(n.Value)
+	})
+}
+
+func (i *preOrderIter[T]) nodes(yield func(*Node[T]) bool) {
 	if i.tree.root == i.tree.nil {
 		return
 	}
-	i.stack = []*Node[T]{i.tree.root}
+	i.stack = i.stack[:0]
+	if cap(i.stack) == 0 {
+		i.stack = make([]*Node[T], 0, i.tree.stackHint()+1)
+	}
+	i.stack = append(i.stack, i.tree.root)
 
 	for len(i.stack) > 0 {
 		node := i.stack[len(i.stack)-1]
 		i.stack = i.stack[:len(i.stack)-1]
 
-		if !yield(node.Value) {
+		if !yield(node) {
 			return
 		}
 
@@ -98,9 +223,20 @@ func (i *preOrderIter[T]) Iterate(yield func(T) bool) {
 }
 
 func (i *postOrderIter[T]) Iterate(yield func(T) bool) {
+	i.nodes(func(n *Node[T]) bool {
+		return n.isTombstone() || yield(n.Value)
+	})
+}
+
+func (i *postOrderIter[T]) nodes(yield func(*Node[T]) bool) {
 	if i.tree.root == i.tree.nil {
 		return
 	}
+	i.stack = i.stack[:0]
+	if cap(i.stack) == 0 {
+		i.stack = make([]*Node[T], 0, i.tree.stackHint())
+	}
+	i.lastVisit = nil
 	current := i.tree.root
 
 	for len(i.stack) > 0 || current != i.tree.nil {
@@ -112,7 +248,7 @@ func (i *postOrderIter[T]) Iterate(yield func(T) bool) {
 			if peekNode.right != i.tree.nil && i.lastVisit != peekNode.right {
 				current = peekNode.right
 			} else {
-				if !yield(peekNode.Value) {
+				if !yield(peekNode) {
 					return
 				}
 				i.lastVisit = i.stack[len(i.stack)-1]
@@ -123,16 +259,29 @@ func (i *postOrderIter[T]) Iterate(yield func(T) bool) {
 }
 
 func (i *levelOrderIter[T]) Iterate(yield func(T) bool) {
+	i.nodes(func(n *Node[T]) bool {
+		return n.isTombstone() || yield(n.Value)
+	})
+}
+
+func (i *levelOrderIter[T]) nodes(yield func(*Node[T]) bool) {
 	if i.tree.root == i.tree.nil {
 		return
 	}
-	i.queue = []*Node[T]{i.tree.root}
+	// every node is appended exactly once so a queue with room for all of
+	// them never has to grow even though it's consumed from the front
+	capacity := 1
+	if i.tree.preallocIter {
+		capacity = i.tree.root.size
+	}
+	i.queue = make([]*Node[T], 0, capacity)
+	i.queue = append(i.queue, i.tree.root)
 
 	for len(i.queue) > 0 {
 		node := i.queue[0]
 		i.queue = i.queue[1:]
 
-		if !yield(node.Value) {
+		if !yield(node) {
 			return
 		}
 
@@ -144,3 +293,452 @@ func (i *levelOrderIter[T]) Iterate(yield func(T) bool) {
 		}
 	}
 }
+
+func (i *sortedLevelOrderIter[T]) Iterate(yield func(T) bool) {
+	i.nodes(func(n *Node[T]) bool {
+		return n.isTombstone() || yield(n.Value)
+	})
+}
+
+func (i *sortedLevelOrderIter[T]) nodes(yield func(*Node[T]) bool) {
+	if i.tree.root == i.tree.nil {
+		return
+	}
+	i.level = append(i.level[:0], i.tree.root)
+
+	for len(i.level) > 0 {
+		slices.SortStableFunc(i.level, func(a, b *Node[T]) int {
+			return i.tree.compare(a.Value, b.Value)
+		})
+
+		i.next = i.next[:0]
+		for _, node := range i.level {
+			if !yield(node) {
+				return
+			}
+
+			if node.left != i.tree.nil {
+				i.next = append(i.next, node.left)
+			}
+			if node.right != i.tree.nil {
+				i.next = append(i.next, node.right)
+			}
+		}
+		i.level, i.next = i.next, i.level
+	}
+}
+
+// Between iterates over the values in the inclusive range [lo, hi]
+// in ascending order. Subtrees that fall entirely outside of the range
+// are never visited. If lo > hi nothing is yielded.
+func (r *RBTree[T]) Between(lo, hi T) func(func(T) bool) {
+	return func(yield func(T) bool) {
+		if r.compare(lo, hi) > 0 {
+			return
+		}
+		r.ascend(lo, func(n *Node[T]) bool {
+			return r.compare(n.Value, hi) <= 0 && yield(n.Value)
+		})
+	}
+}
+
+// BetweenReverse iterates over the values in the inclusive range
+// [lo, hi] in descending order. Subtrees that fall entirely outside of
+// the range are never visited. If lo > hi nothing is yielded.
+func (r *RBTree[T]) BetweenReverse(lo, hi T) func(func(T) bool) {
+	return func(yield func(T) bool) {
+		if r.compare(lo, hi) > 0 {
+			return
+		}
+		r.descend(hi, func(n *Node[T]) bool {
+			return r.compare(n.Value, lo) >= 0 && yield(n.Value)
+		})
+	}
+}
+
+// Outside iterates in ascending order over the values that are not in
+// the inclusive range [lo, hi], first those below lo and then those above
+// hi, the complement of Between. Subtrees that fall entirely inside the
+// range are never visited. If lo > hi every value is yielded.
+func (r *RBTree[T]) Outside(lo, hi T) func(func(T) bool) {
+	return func(yield func(T) bool) {
+		if r.compare(lo, hi) > 0 {
+			r.Iterate(InOrder)(yield)
+			return
+		}
+
+		stopped := false
+		r.ascendBelow(lo, func(n *Node[T]) bool {
+			stopped = !yield(n.Value)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+		r.ascend(hi, func(n *Node[T]) bool {
+			return r.compare(n.Value, hi) == 0 || yield(n.Value)
+		})
+	}
+}
+
+// RangeHalfOpen iterates over the values in the half-open range
+// [lo, hi) in ascending order, matching the conventions of Go slice
+// expressions. If lo >= hi nothing is yielded.
+func (r *RBTree[T]) RangeHalfOpen(lo, hi T) func(func(T) bool) {
+	return func(yield func(T) bool) {
+		if r.compare(lo, hi) >= 0 {
+			return
+		}
+		r.ascend(lo, func(n *Node[T]) bool {
+			return r.compare(n.Value, hi) < 0 && yield(n.Value)
+		})
+	}
+}
+
+// IterateAfter returns up to limit values strictly greater than key in
+// ascending order, the next page after key in a paginated listing.
+func (r *RBTree[T]) IterateAfter(key T, limit int) []T {
+	var page []T
+	if limit <= 0 {
+		return page
+	}
+	r.ascend(key, func(n *Node[T]) bool {
+		if r.compare(n.Value, key) == 0 {
+			return true
+		}
+		page = append(page, n.Value)
+		return len(page) < limit
+	})
+	return page
+}
+
+// IterateBefore returns up to limit values strictly less than key in
+// descending order, the previous page before key in a paginated listing.
+func (r *RBTree[T]) IterateBefore(key T, limit int) []T {
+	var page []T
+	if limit <= 0 {
+		return page
+	}
+	r.descend(key, func(n *Node[T]) bool {
+		if r.compare(n.Value, key) == 0 {
+			return true
+		}
+		page = append(page, n.Value)
+		return len(page) < limit
+	})
+	return page
+}
+
+// ascend walks the nodes >= lo in ascending order until yield returns
+// false, subtrees entirely below lo are never visited. Tombstones are
+// skipped.
+func (r *RBTree[T]) ascend(lo T, yield func(*Node[T]) bool) {
+	var stack []*Node[T]
+	current := r.root
+	for current != r.nil || len(stack) > 0 {
+		for current != r.nil {
+			if r.compare(current.Value, lo) < 0 {
+				// current and its left subtree are below the range
+				current = current.right
+				continue
+			}
+			stack = append(stack, current)
+			current = current.left
+		}
+		if len(stack) == 0 {
+			return
+		}
+		current = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if !current.isTombstone() && !yield(current) {
+			return
+		}
+
+		current = current.right
+	}
+}
+
+// ascendBelow walks the nodes < hi in ascending order until yield returns
+// false, subtrees entirely at or above hi are never visited. Tombstones
+// are skipped.
+func (r *RBTree[T]) ascendBelow(hi T, yield func(*Node[T]) bool) {
+	var stack []*Node[T]
+	current := r.root
+	for current != r.nil || len(stack) > 0 {
+		for current != r.nil {
+			if r.compare(current.Value, hi) >= 0 {
+				// current and its right subtree are not below hi
+				current = current.left
+				continue
+			}
+			stack = append(stack, current)
+			current = current.left
+		}
+		if len(stack) == 0 {
+			return
+		}
+		current = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if !current.isTombstone() && !yield(current) {
+			return
+		}
+
+		current = current.right
+	}
+}
+
+// descend walks the nodes <= hi in descending order until yield returns
+// false, subtrees entirely above hi are never visited. Tombstones are
+// skipped.
+func (r *RBTree[T]) descend(hi T, yield func(*Node[T]) bool) {
+	var stack []*Node[T]
+	current := r.root
+	for current != r.nil || len(stack) > 0 {
+		for current != r.nil {
+			if r.compare(current.Value, hi) > 0 {
+				// current and its right subtree are above the range
+				current = current.left
+				continue
+			}
+			stack = append(stack, current)
+			current = current.right
+		}
+		if len(stack) == 0 {
+			return
+		}
+		current = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if !current.isTombstone() && !yield(current) {
+			return
+		}
+
+		current = current.left
+	}
+}
+
+// Drain iterates over the values in ascending order removing each from
+// the tree as it's yielded. Every value that has been yielded is removed,
+// including the one for which yield returned false, so breaking early
+// leaves the remaining values in the tree.
+func (r *RBTree[T]) Drain() func(func(T) bool) {
+	return func(yield func(T) bool) {
+		for r.root != r.nil {
+			n := r.minimum(r.root)
+			dead, v := n.isTombstone(), n.Value
+			r.DeleteNode(n)
+			r.freeNode(n)
+			if dead {
+				continue
+			}
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// DrainWhile is like Drain but stops at the first value for which pred
+// returns false, leaving it and everything after it in the tree. This
+// suits a worker that takes due tasks off the front of a queue.
+func (r *RBTree[T]) DrainWhile(pred func(T) bool) func(func(T) bool) {
+	return func(yield func(T) bool) {
+		for r.root != r.nil {
+			n := r.minimum(r.root)
+			dead, v := n.isTombstone(), n.Value
+			if !dead && !pred(v) {
+				return
+			}
+			r.DeleteNode(n)
+			r.freeNode(n)
+			if dead {
+				continue
+			}
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// IterateFromKey iterates in ascending order starting from the smallest
+// value >= start. If wrap is true, after reaching the largest value the
+// iteration continues from the smallest value until it gets back to
+// where it started, visiting every value exactly once.
+func (r *RBTree[T]) IterateFromKey(start T, wrap bool) func(func(T) bool) {
+	return func(yield func(T) bool) {
+		first := r.ceiling(start)
+		for n := first; n != nil; n = r.Successor(n) {
+			if !n.isTombstone() && !yield(n.Value) {
+				return
+			}
+		}
+
+		if !wrap {
+			return
+		}
+		for n := r.minimum(r.root); n != nil && n != first; n = r.Successor(n) {
+			if !n.isTombstone() && !yield(n.Value) {
+				return
+			}
+		}
+	}
+}
+
+// IterateRanked iterates in ascending order yielding each value along
+// with its 0-based rank. Ranks are contiguous from 0 to Len()-1.
+func (r *RBTree[T]) IterateRanked() func(func(rank int, v T) bool) {
+	return func(yield func(rank int, v T) bool) {
+		rank := 0
+		iterator := inOrderIter[T]{tree: r}
+		iterator.Iterate(func(v T) bool {
+			if !yield(rank, v) {
+				return false
+			}
+			rank++
+			return true
+		})
+	}
+}
+
+// IteratePairs iterates in ascending order over every pair of adjacent
+// values, yielding (v0, v1), (v1, v2) and so on. Trees with fewer than two
+// values yield nothing.
+func (r *RBTree[T]) IteratePairs() func(func(a, b T) bool) {
+	return func(yield func(a, b T) bool) {
+		var prev *Node[T]
+		iterator := inOrderIter[T]{tree: r}
+		iterator.nodes(func(n *Node[T]) bool {
+			if n.isTombstone() {
+				return true
+			}
+			ok := prev == nil || yield(prev.Value, n.Value)
+			prev = n
+			return ok
+		})
+	}
+}
+
+// IterateChunks iterates in ascending order yielding the values in slices
+// of size values, the final chunk may be shorter. Each chunk is freshly
+// allocated so it's safe to retain. Panics if size is not positive.
+func (r *RBTree[T]) IterateChunks(size int) func(func([]T) bool) {
+	if size <= 0 {
+		panic("chunk size must be positive")
+	}
+
+	return func(yield func([]T) bool) {
+		chunk := make([]T, 0, min(size, r.Len()))
+		stopped := false
+		r.Iterate(InOrder)(func(v T) bool {
+			chunk = append(chunk, v)
+			if len(chunk) < size {
+				return true
+			}
+			if !yield(chunk) {
+				stopped = true
+				return false
+			}
+			chunk = make([]T, 0, size)
+			return true
+		})
+
+		if !stopped && len(chunk) > 0 {
+			yield(chunk)
+		}
+	}
+}
+
+// BucketedIterate iterates in ascending order yielding every maximal run
+// of values that bucket maps to the same key together with that key, such
+// as the values of each time window. bucket should be monotonic under the
+// comparator, otherwise a key is yielded once for every separate run of
+// it. Each run is freshly allocated so it's safe to retain.
+func BucketedIterate[T any, B comparable](r *RBTree[T], bucket func(T) B) func(func(B, []T) bool) {
+	return func(yield func(B, []T) bool) {
+		var key B
+		var run []T
+		stopped := false
+		r.Iterate(InOrder)(func(v T) bool {
+			k := bucket(v)
+			if len(run) > 0 && k != key {
+				if !yield(key, run) {
+					stopped = true
+					return false
+				}
+				run = nil
+			}
+			key = k
+			run = append(run, v)
+			return true
+		})
+
+		if !stopped && len(run) > 0 {
+			yield(key, run)
+		}
+	}
+}
+
+// MapFilter iterates over r in ascending order yielding project(v) for
+// every value v where keep(v) is true, without allocating any
+// intermediate collections.
+func MapFilter[T, U any](r *RBTree[T], keep func(T) bool, project func(T) U) func(func(U) bool) {
+	return func(yield func(U) bool) {
+		r.Iterate(InOrder)(func(v T) bool {
+			return !keep(v) || yield(project(v))
+		})
+	}
+}
+
+// RangeSummary counts the values in the inclusive range [lo, hi] and
+// folds them into an aggregate with add, starting from 0, in a single
+// pruned walk of the range. If lo > hi it returns 0, 0.
+func RangeSummary[T any](r *RBTree[T], lo, hi T, add func(acc float64, v T) float64) (count int, agg float64) {
+	if r.compare(lo, hi) > 0 {
+		return 0, 0
+	}
+	r.ascend(lo, func(n *Node[T]) bool {
+		if r.compare(n.Value, hi) > 0 {
+			return false
+		}
+		count++
+		agg = add(agg, n.Value)
+		return true
+	})
+	return count, agg
+}
+
+// IterateLeaves iterates in ascending order over only the values of
+// leaf nodes, those whose children are both the sentinel.
+func (r *RBTree[T]) IterateLeaves() func(func(T) bool) {
+	return func(yield func(T) bool) {
+		iterator := inOrderIter[T]{tree: r}
+		iterator.nodes(func(n *Node[T]) bool {
+			if n.isTombstone() || n.left != r.nil || n.right != r.nil {
+				return true
+			}
+			return yield(n.Value)
+		})
+	}
+}
+
+// IterateSince iterates in ascending order over only the values whose
+// nodes have a sequence number >= seq, those inserted since seq was
+// handed out. Recording LastSeq()+1 after taking a snapshot and passing
+// it here later yields the additions needed for a delta snapshot.
+//
+// Deletes are not tracked so removed values simply go missing. The whole
+// tree is walked.
+func (r *RBTree[T]) IterateSince(seq uint64) func(func(T) bool) {
+	return func(yield func(T) bool) {
+		iterator := inOrderIter[T]{tree: r}
+		iterator.nodes(func(n *Node[T]) bool {
+			if n.isTombstone() || n.seq < seq {
+				return true
+			}
+			return yield(n.Value)
+		})
+	}
+}
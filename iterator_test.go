@@ -2,7 +2,9 @@ package rbtree
 
 import (
 	"cmp"
+	"math/rand"
 	"slices"
+	"strconv"
 	"testing"
 )
 
@@ -54,3 +56,852 @@ func TestIterators(t *testing.T) {
 		}
 	})
 }
+
+func TestBetween(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 20; i++ {
+		tree.Insert(i * 2)
+	}
+
+	tests := []struct {
+		name   string
+		lo, hi int
+		want   []int
+	}{
+		{"Inner", 5, 13, []int{6, 8, 10, 12}},
+		{"ExactBounds", 6, 12, []int{6, 8, 10, 12}},
+		{"Single", 8, 8, []int{8}},
+		{"Everything", 0, 100, []int{2, 4, 6, 8, 10, 12, 14, 16, 18, 20, 22, 24, 26, 28, 30, 32, 34, 36, 38, 40}},
+		{"Below", -10, 1, nil},
+		{"Above", 41, 50, nil},
+		{"Gap", 9, 9, nil},
+		{"Inverted", 12, 6, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := runIterator(tree.Between(test.lo, test.hi))
+			if !slices.Equal(out, test.want) {
+				t.Errorf("slices differ:\n%#v\n%#v", out, test.want)
+			}
+
+			out = runIterator(tree.BetweenReverse(test.lo, test.hi))
+			want := slices.Clone(test.want)
+			slices.Reverse(want)
+			if !slices.Equal(out, want) {
+				t.Errorf("reverse slices differ:\n%#v\n%#v", out, want)
+			}
+		})
+	}
+
+	t.Run("ReverseEarlyTermination", func(t *testing.T) {
+		var out []int
+		tree.BetweenReverse(5, 30)(func(v int) bool {
+			out = append(out, v)
+			return len(out) < 3
+		})
+		want := []int{30, 28, 26}
+		if !slices.Equal(out, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, want)
+		}
+	})
+}
+
+func TestIterateCopy(t *testing.T) {
+	type item struct {
+		key  int
+		tags []string
+	}
+	tree := New(func(a, b item) int { return cmp.Compare(a.key, b.key) })
+	for i := 0; i < 5; i++ {
+		tree.Insert(item{key: i, tags: []string{"original"}})
+	}
+
+	clone := func(i item) item {
+		i.tags = slices.Clone(i.tags)
+		return i
+	}
+
+	var keys []int
+	tree.IterateCopy(InOrder, clone)(func(i item) bool {
+		keys = append(keys, i.key)
+		i.tags[0] = "mutated"
+		return true
+	})
+	if want := []int{0, 1, 2, 3, 4}; !slices.Equal(keys, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", keys, want)
+	}
+
+	tree.Iterate(InOrder)(func(i item) bool {
+		if i.tags[0] != "original" {
+			t.Errorf("stored value %d was mutated: %s", i.key, i.tags[0])
+		}
+		return true
+	})
+
+	// demonstrate the aliasing that IterateCopy protects against
+	tree.Iterate(InOrder)(func(i item) bool {
+		i.tags[0] = "mutated"
+		return false
+	})
+	if got := tree.Search(item{key: 0}).Value.tags[0]; got != "mutated" {
+		t.Errorf("expected aliased mutation, got: %s", got)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	newTree := func() *RBTree[int] {
+		tree := New(cmp.Compare[int])
+		for _, v := range []int{5, 3, 8, 1, 9, 2, 7, 4, 6, 10} {
+			tree.Insert(v)
+		}
+		return tree
+	}
+
+	t.Run("Full", func(t *testing.T) {
+		tree := newTree()
+		out := runIterator(tree.Drain())
+		if want := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}; !slices.Equal(out, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, want)
+		}
+		if tree.Len() != 0 || tree.root != tree.nil {
+			t.Errorf("tree should be empty, has %d", tree.Len())
+		}
+	})
+
+	t.Run("Partial", func(t *testing.T) {
+		tree := newTree()
+		var out []int
+		tree.Drain()(func(v int) bool {
+			out = append(out, v)
+			return v < 4
+		})
+		if want := []int{1, 2, 3, 4}; !slices.Equal(out, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, want)
+		}
+
+		isRedBlackTree(t, tree, tree.root)
+		rest := runIterator(tree.Iterate(InOrder))
+		if want := []int{5, 6, 7, 8, 9, 10}; !slices.Equal(rest, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", rest, want)
+		}
+	})
+}
+
+func TestDrainWhile(t *testing.T) {
+	newTree := func() *RBTree[int] {
+		tree := New(cmp.Compare[int], WithLazyDelete[int]())
+		for _, v := range []int{5, 3, 8, 1, 9, 2, 7, 4, 6, 10} {
+			tree.Insert(v)
+		}
+		return tree
+	}
+	below := func(limit int) func(int) bool {
+		return func(v int) bool { return v < limit }
+	}
+
+	t.Run("Threshold", func(t *testing.T) {
+		tree := newTree()
+		// a deleted value at the front is skipped, not tested
+		tree.Delete(1)
+		out := runIterator(tree.DrainWhile(below(6)))
+		if want := []int{2, 3, 4, 5}; !slices.Equal(out, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, want)
+		}
+		isRedBlackTree(t, tree, tree.root)
+		if rest, want := tree.Flatten(), []int{6, 7, 8, 9, 10}; !slices.Equal(rest, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", rest, want)
+		}
+		if tree.Min().Value != 6 {
+			t.Errorf("the first failing value should stay put, min is %d", tree.Min().Value)
+		}
+	})
+
+	t.Run("Everything", func(t *testing.T) {
+		tree := newTree()
+		if out := runIterator(tree.DrainWhile(below(100))); len(out) != 10 || tree.Len() != 0 {
+			t.Errorf("expected everything to drain: %#v", out)
+		}
+	})
+
+	t.Run("Nothing", func(t *testing.T) {
+		tree := newTree()
+		if out := runIterator(tree.DrainWhile(below(1))); len(out) != 0 || tree.Len() != 10 {
+			t.Errorf("expected nothing to drain: %#v", out)
+		}
+	})
+
+	t.Run("Break", func(t *testing.T) {
+		tree := newTree()
+		var out []int
+		tree.DrainWhile(below(8))(func(v int) bool {
+			out = append(out, v)
+			return v < 2
+		})
+		if want := []int{1, 2}; !slices.Equal(out, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, want)
+		}
+		if rest, want := tree.Flatten(), []int{3, 4, 5, 6, 7, 8, 9, 10}; !slices.Equal(rest, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", rest, want)
+		}
+	})
+}
+
+func TestIterateFromKey(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 5; i++ {
+		tree.Insert(i * 10)
+	}
+
+	tests := []struct {
+		name  string
+		start int
+		wrap  bool
+		want  []int
+	}{
+		{"Exact", 30, false, []int{30, 40, 50}},
+		{"Between", 25, false, []int{30, 40, 50}},
+		{"PastEnd", 51, false, nil},
+		{"ExactWrap", 30, true, []int{30, 40, 50, 10, 20}},
+		{"BetweenWrap", 35, true, []int{40, 50, 10, 20, 30}},
+		{"FirstWrap", 10, true, []int{10, 20, 30, 40, 50}},
+		{"BeforeFirstWrap", 0, true, []int{10, 20, 30, 40, 50}},
+		{"PastEndWrap", 51, true, []int{10, 20, 30, 40, 50}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := runIterator(tree.IterateFromKey(test.start, test.wrap))
+			if !slices.Equal(out, test.want) {
+				t.Errorf("slices differ:\n%#v\n%#v", out, test.want)
+			}
+		})
+	}
+
+	t.Run("EarlyTermination", func(t *testing.T) {
+		var out []int
+		tree.IterateFromKey(40, true)(func(v int) bool {
+			out = append(out, v)
+			return len(out) < 3
+		})
+		if want := []int{40, 50, 10}; !slices.Equal(out, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, want)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		out := runIterator(New(cmp.Compare[int]).IterateFromKey(5, true))
+		if len(out) != 0 {
+			t.Errorf("expected nothing, got: %#v", out)
+		}
+	})
+}
+
+func TestIterateRanked(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for _, v := range []int{40, 10, 30, 50, 20} {
+		tree.Insert(v)
+	}
+
+	want := tree.Flatten()
+	count := 0
+	tree.IterateRanked()(func(rank int, v int) bool {
+		if rank != count {
+			t.Errorf("ranks not contiguous, want: %d got: %d", count, rank)
+		}
+		if want[rank] != v {
+			t.Errorf("rank %d want: %d got: %d", rank, want[rank], v)
+		}
+		if tree.Rank(v) != rank {
+			t.Errorf("rank %d disagrees with Rank: %d", rank, tree.Rank(v))
+		}
+		count++
+		return true
+	})
+	if count != len(want) {
+		t.Errorf("want: %d values got: %d", len(want), count)
+	}
+}
+
+func TestIterateChunks(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i)
+	}
+
+	collect := func(size int) [][]int {
+		var chunks [][]int
+		tree.IterateChunks(size)(func(chunk []int) bool {
+			chunks = append(chunks, chunk)
+			return true
+		})
+		return chunks
+	}
+
+	t.Run("ShortFinal", func(t *testing.T) {
+		chunks := collect(4)
+		want := [][]int{{1, 2, 3, 4}, {5, 6, 7, 8}, {9, 10}}
+		if !slices.EqualFunc(chunks, want, slices.Equal) {
+			t.Errorf("chunks differ:\n%#v\n%#v", chunks, want)
+		}
+	})
+
+	t.Run("Exact", func(t *testing.T) {
+		chunks := collect(5)
+		want := [][]int{{1, 2, 3, 4, 5}, {6, 7, 8, 9, 10}}
+		if !slices.EqualFunc(chunks, want, slices.Equal) {
+			t.Errorf("chunks differ:\n%#v\n%#v", chunks, want)
+		}
+	})
+
+	t.Run("Coverage", func(t *testing.T) {
+		for size := 1; size <= 12; size++ {
+			var all []int
+			for _, chunk := range collect(size) {
+				if len(chunk) > size {
+					t.Errorf("chunk larger than %d: %#v", size, chunk)
+				}
+				all = append(all, chunk...)
+			}
+			if want := tree.Flatten(); !slices.Equal(all, want) {
+				t.Errorf("size %d slices differ:\n%#v\n%#v", size, all, want)
+			}
+		}
+	})
+
+	t.Run("EarlyTermination", func(t *testing.T) {
+		count := 0
+		tree.IterateChunks(3)(func(chunk []int) bool {
+			count++
+			return false
+		})
+		if count != 1 {
+			t.Errorf("want: 1 got: %d", count)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		count := 0
+		New(cmp.Compare[int]).IterateChunks(3)(func(chunk []int) bool {
+			count++
+			return true
+		})
+		if count != 0 {
+			t.Errorf("want: 0 got: %d", count)
+		}
+	})
+}
+
+func TestRangeHalfOpen(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i * 10)
+	}
+
+	tests := []struct {
+		name   string
+		lo, hi int
+		want   []int
+	}{
+		{"ExistingBounds", 30, 60, []int{30, 40, 50}},
+		{"MissingBounds", 25, 65, []int{30, 40, 50, 60}},
+		{"Adjacent", 30, 40, []int{30}},
+		{"EqualExisting", 30, 30, nil},
+		{"EqualMissing", 35, 35, nil},
+		{"Inverted", 60, 30, nil},
+		{"Everything", 0, 1000, []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}},
+		{"UpToMax", 90, 100, []int{90}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := runIterator(tree.RangeHalfOpen(test.lo, test.hi))
+			if !slices.Equal(out, test.want) {
+				t.Errorf("slices differ:\n%#v\n%#v", out, test.want)
+			}
+		})
+	}
+}
+
+func TestMapFilter(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for _, v := range []int{7, 2, 9, 4, 1, 8, 3, 6, 5, 10} {
+		tree.Insert(v)
+	}
+
+	isEven := func(v int) bool { return v%2 == 0 }
+	square := func(v int) string { return strconv.Itoa(v * v) }
+
+	var out []string
+	MapFilter(tree, isEven, square)(func(v string) bool {
+		out = append(out, v)
+		return true
+	})
+	if want := []string{"4", "16", "36", "64", "100"}; !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+
+	out = nil
+	MapFilter(tree, isEven, square)(func(v string) bool {
+		out = append(out, v)
+		return len(out) < 2
+	})
+	if want := []string{"4", "16"}; !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+}
+
+func TestIterateLeaves(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i)
+	}
+
+	// see TestNodeSize for the shape
+	out := runIterator(tree.IterateLeaves())
+	if want := []int{1, 3, 5, 7, 10}; !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+
+	single := New(cmp.Compare[int])
+	single.Insert(1)
+	if out := runIterator(single.IterateLeaves()); !slices.Equal(out, []int{1}) {
+		t.Errorf("a lone root is a leaf: %#v", out)
+	}
+}
+
+func TestNodeIterate(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i)
+	}
+
+	for _, method := range []IterationMethod{InOrder, PreOrder, PostOrder, LevelOrder, LevelOrderSorted} {
+		var out []int
+		tree.NodeIterate(method)(func(n *Node[int]) bool {
+			if tree.Search(n.Value) != n {
+				t.Errorf("yielded a node that isn't in the tree: %d", n.Value)
+			}
+			out = append(out, n.Value)
+			return true
+		})
+		if want := runIterator(tree.Iterate(method)); !slices.Equal(out, want) {
+			t.Errorf("method %d slices differ:\n%#v\n%#v", method, out, want)
+		}
+	}
+}
+
+func TestIteratorReuse(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i)
+	}
+
+	for _, method := range []IterationMethod{InOrder, PreOrder, PostOrder, LevelOrder, LevelOrderSorted} {
+		iterate := tree.Iterate(method)
+		iterate(func(int) bool { return false })
+		if out, want := runIterator(iterate), runIterator(tree.Iterate(method)); !slices.Equal(out, want) {
+			t.Errorf("method %d slices differ after reuse:\n%#v\n%#v", method, out, want)
+		}
+	}
+}
+
+type largeValue struct {
+	key     int
+	payload [64]int
+}
+
+// BenchmarkIterateLargeValues compares yielding large values by copy to
+// yielding node pointers.
+func BenchmarkIterateLargeValues(b *testing.B) {
+	tree := New(func(a, b largeValue) int { return cmp.Compare(a.key, b.key) })
+	for i := 0; i < 10000; i++ {
+		tree.Insert(largeValue{key: i})
+	}
+
+	b.Run("Value", func(b *testing.B) {
+		sum := 0
+		for i := 0; i < b.N; i++ {
+			tree.Iterate(InOrder)(func(v largeValue) bool {
+				sum += v.payload[0]
+				return true
+			})
+		}
+	})
+	b.Run("Node", func(b *testing.B) {
+		sum := 0
+		for i := 0; i < b.N; i++ {
+			tree.NodeIterate(InOrder)(func(n *Node[largeValue]) bool {
+				sum += n.Value.payload[0]
+				return true
+			})
+		}
+	})
+}
+
+func TestIteratePrealloc(t *testing.T) {
+	tree, prealloc := New(cmp.Compare[int]), New(cmp.Compare[int], WithIteratorPrealloc[int]())
+	for _, v := range rand.Perm(100) {
+		tree.Insert(v)
+		prealloc.Insert(v)
+	}
+
+	for _, method := range []IterationMethod{InOrder, PreOrder, PostOrder, LevelOrder} {
+		if out, want := runIterator(prealloc.Iterate(method)), runIterator(tree.Iterate(method)); !slices.Equal(out, want) {
+			t.Errorf("method %d slices differ:\n%#v\n%#v", method, out, want)
+		}
+	}
+
+	empty := New(cmp.Compare[int], WithIteratorPrealloc[int]())
+	for _, method := range []IterationMethod{InOrder, PreOrder, PostOrder, LevelOrder} {
+		if out := runIterator(empty.Iterate(method)); len(out) != 0 {
+			t.Errorf("method %d yielded values from an empty tree: %#v", method, out)
+		}
+	}
+}
+
+// BenchmarkIteratePrealloc compares the allocations of an in-order walk
+// over a large tree with and without WithIteratorPrealloc.
+func BenchmarkIteratePrealloc(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		opts []Option[int]
+	}{
+		{"Append", nil},
+		{"Prealloc", []Option[int]{WithIteratorPrealloc[int]()}},
+	} {
+		tree := New(cmp.Compare[int], bench.opts...)
+		for i := 0; i < 1000000; i++ {
+			tree.Insert(i)
+		}
+
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			sum := 0
+			for i := 0; i < b.N; i++ {
+				tree.Iterate(InOrder)(func(v int) bool {
+					sum += v
+					return true
+				})
+			}
+		})
+	}
+}
+
+func TestIterateSince(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for _, v := range []int{50, 10, 30} {
+		tree.Insert(v)
+	}
+	since := tree.LastSeq() + 1
+
+	for _, v := range []int{40, 20, 60} {
+		tree.Insert(v)
+	}
+	want := []int{20, 40, 60}
+	if out := runIterator(tree.IterateSince(since)); !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+
+	since = tree.LastSeq() + 1
+	if out := runIterator(tree.IterateSince(since)); len(out) != 0 {
+		t.Errorf("expected nothing new: %#v", out)
+	}
+	tree.Insert(0)
+	tree.Delete(10)
+	want = []int{0}
+	if out := runIterator(tree.IterateSince(since)); !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+
+	want = tree.Flatten()
+	if out := runIterator(tree.IterateSince(0)); !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+}
+
+func TestIterateBeforeAfter(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 10; i <= 100; i += 10 {
+		tree.Insert(i)
+	}
+
+	tests := []struct {
+		name   string
+		key    int
+		limit  int
+		before []int
+		after  []int
+	}{
+		{"Middle", 50, 3, []int{40, 30, 20}, []int{60, 70, 80}},
+		{"Between", 55, 2, []int{50, 40}, []int{60, 70}},
+		{"BelowMin", 5, 3, nil, []int{10, 20, 30}},
+		{"AboveMax", 105, 2, []int{100, 90}, nil},
+		{"LimitExceeds", 30, 10, []int{20, 10}, []int{40, 50, 60, 70, 80, 90, 100}},
+		{"ZeroLimit", 50, 0, nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if out := tree.IterateBefore(test.key, test.limit); !slices.Equal(out, test.before) {
+				t.Errorf("before slices differ:\n%#v\n%#v", out, test.before)
+			}
+			if out := tree.IterateAfter(test.key, test.limit); !slices.Equal(out, test.after) {
+				t.Errorf("after slices differ:\n%#v\n%#v", out, test.after)
+			}
+		})
+	}
+}
+
+func TestLevelOrderSorted(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i)
+	}
+
+	// a correctly ordered tree is already sorted within each level
+	want := []int{4, 2, 6, 1, 3, 5, 8, 7, 9, 10}
+	if out := runIterator(tree.Iterate(LevelOrderSorted)); !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+
+	// swap values across the root so the structural order of the levels
+	// no longer matches their sorted order
+	tree.Search(2).Value = 20
+	tree.Search(8).Value = 0
+	tree.Search(1).Value = 11
+
+	structural := []int{4, 20, 6, 11, 3, 5, 0, 7, 9, 10}
+	if out := runIterator(tree.Iterate(LevelOrder)); !slices.Equal(out, structural) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, structural)
+	}
+	want = []int{4, 6, 20, 0, 3, 5, 11, 7, 9, 10}
+	if out := runIterator(tree.Iterate(LevelOrderSorted)); !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+}
+
+func TestIterateSnapshot(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i)
+	}
+
+	for _, method := range []IterationMethod{InOrder, PreOrder, PostOrder, LevelOrder} {
+		if out, want := runIterator(tree.IterateSnapshot(method)), runIterator(tree.Iterate(method)); !slices.Equal(out, want) {
+			t.Errorf("method %d slices differ:\n%#v\n%#v", method, out, want)
+		}
+	}
+
+	// deleting the upcoming values during the walk doesn't affect the
+	// snapshot but the live walk never sees them
+	snapshot := tree.IterateSnapshot(InOrder)
+	var out []int
+	snapshot(func(v int) bool {
+		tree.Delete(v + 1)
+		out = append(out, v)
+		return true
+	})
+	want := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+	if out := runIterator(snapshot); !slices.Equal(out, want) {
+		t.Errorf("snapshot changed on reuse:\n%#v\n%#v", out, want)
+	}
+
+	tree = New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i)
+	}
+	out = nil
+	tree.Iterate(InOrder)(func(v int) bool {
+		tree.Delete(v + 1)
+		out = append(out, v)
+		return true
+	})
+	if slices.Equal(out, want) {
+		t.Error("the live walk should observe the deletes")
+	}
+}
+
+func TestIteratePairs(t *testing.T) {
+	tree := New(cmp.Compare[int], WithLazyDelete[int]())
+	for _, v := range rand.Perm(20) {
+		tree.Insert(v * 3)
+	}
+	tree.Delete(9)
+
+	type pair struct {
+		a, b int
+	}
+	sorted := tree.Flatten()
+	var want []pair
+	for i := range sorted[1:] {
+		want = append(want, pair{sorted[i], sorted[i+1]})
+	}
+
+	var out []pair
+	tree.IteratePairs()(func(a, b int) bool {
+		out = append(out, pair{a, b})
+		return true
+	})
+	if !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+
+	count := 0
+	tree.IteratePairs()(func(a, b int) bool {
+		count++
+		return count < 3
+	})
+	if count != 3 {
+		t.Errorf("want: 3 got: %d", count)
+	}
+
+	single := New(cmp.Compare[int])
+	single.Insert(1)
+	for _, tree := range []*RBTree[int]{single, New(cmp.Compare[int])} {
+		tree.IteratePairs()(func(a, b int) bool {
+			t.Errorf("unexpected pair %d, %d", a, b)
+			return true
+		})
+	}
+}
+
+func TestRangeSummary(t *testing.T) {
+	tree := New(cmp.Compare[int], WithLazyDelete[int]())
+	var vals []int
+	for _, v := range rand.Perm(500) {
+		tree.Insert(v * 2)
+	}
+	for i := 0; i < 500; i += 7 {
+		tree.Delete(i * 2)
+	}
+	tree.Iterate(InOrder)(func(v int) bool {
+		vals = append(vals, v)
+		return true
+	})
+
+	sum := func(acc float64, v int) float64 { return acc + float64(v) }
+	ranges := [][2]int{{0, 998}, {-10, 5}, {101, 101}, {100, 100}, {250, 731}, {990, 2000}, {5, 4}, {2000, 3000}}
+	for _, rng := range ranges {
+		lo, hi := rng[0], rng[1]
+		wantCount, wantSum := 0, 0.0
+		for _, v := range vals {
+			if v >= lo && v <= hi {
+				wantCount++
+				wantSum += float64(v)
+			}
+		}
+
+		count, agg := RangeSummary(tree, lo, hi, sum)
+		if count != wantCount || agg != wantSum {
+			t.Errorf("[%d, %d] want: %d, %v got: %d, %v", lo, hi, wantCount, wantSum, count, agg)
+		}
+	}
+}
+
+func TestOutside(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 20; i++ {
+		tree.Insert(i * 2)
+	}
+	all := tree.Flatten()
+
+	ranges := [][2]int{{5, 13}, {6, 12}, {8, 8}, {9, 9}, {0, 100}, {-10, 1}, {41, 50}, {-5, 20}, {20, 45}, {12, 6}}
+	for _, rng := range ranges {
+		lo, hi := rng[0], rng[1]
+		var want []int
+		for _, v := range all {
+			if lo > hi || v < lo || v > hi {
+				want = append(want, v)
+			}
+		}
+		if got := runIterator(tree.Outside(lo, hi)); !slices.Equal(got, want) {
+			t.Errorf("[%d, %d] slices differ:\n%#v\n%#v", lo, hi, got, want)
+		}
+	}
+
+	t.Run("EarlyTermination", func(t *testing.T) {
+		for _, limit := range []int{1, 3, 5} {
+			var out []int
+			tree.Outside(7, 35)(func(v int) bool {
+				out = append(out, v)
+				return len(out) < limit
+			})
+			if want := []int{2, 4, 6, 36, 38}[:limit]; !slices.Equal(out, want) {
+				t.Errorf("slices differ:\n%#v\n%#v", out, want)
+			}
+		}
+	})
+
+	t.Run("Pruning", func(t *testing.T) {
+		calls := 0
+		big := New(func(a, b int) int {
+			calls++
+			return cmp.Compare(a, b)
+		})
+		for i := 0; i < 10000; i++ {
+			big.Insert(i)
+		}
+
+		calls = 0
+		if got := runIterator(big.Outside(1, 9997)); !slices.Equal(got, []int{0, 9998, 9999}) {
+			t.Errorf("slices differ:\n%#v\n%#v", got, []int{0, 9998, 9999})
+		}
+		if calls > 200 {
+			t.Errorf("the range inside should be pruned, %d comparisons", calls)
+		}
+	})
+}
+
+func TestBucketedIterate(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for _, v := range []int{3, 14, 7, 0, 41, 19, 12, 45, 9, 40} {
+		tree.Insert(v)
+	}
+
+	type group struct {
+		bucket int
+		vals   []int
+	}
+	var got []group
+	BucketedIterate(tree, func(v int) int { return v / 10 })(func(bucket int, vals []int) bool {
+		got = append(got, group{bucket, vals})
+		return true
+	})
+	want := []group{
+		{0, []int{0, 3, 7, 9}},
+		{1, []int{12, 14, 19}},
+		{4, []int{40, 41, 45}},
+	}
+	if !slices.EqualFunc(got, want, func(a, b group) bool {
+		return a.bucket == b.bucket && slices.Equal(a.vals, b.vals)
+	}) {
+		t.Errorf("slices differ:\n%#v\n%#v", got, want)
+	}
+
+	// retained runs are not overwritten by later ones
+	if got[0].vals[0] != 0 || got[1].vals[0] != 12 {
+		t.Errorf("runs share memory: %#v", got)
+	}
+
+	t.Run("EarlyTermination", func(t *testing.T) {
+		var buckets []int
+		BucketedIterate(tree, func(v int) int { return v / 10 })(func(bucket int, vals []int) bool {
+			buckets = append(buckets, bucket)
+			return bucket < 1
+		})
+		if want := []int{0, 1}; !slices.Equal(buckets, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", buckets, want)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		BucketedIterate(New(cmp.Compare[int]), func(v int) int { return v })(func(int, []int) bool {
+			t.Error("nothing should be yielded")
+			return true
+		})
+	})
+}
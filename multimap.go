@@ -0,0 +1,60 @@
+package rbtree
+
+// MultiMap is an ordered map from keys to groups of values, backed by a
+// red black tree ordered on the keys.
+type MultiMap[K comparable, V any] struct {
+	tree *RBTree[multiMapEntry[K, V]]
+}
+
+type multiMapEntry[K comparable, V any] struct {
+	key    K
+	values []V
+}
+
+// NewMultiMap constructs a MultiMap with keys ordered by compare.
+func NewMultiMap[K comparable, V any](compare func(a, b K) int) *MultiMap[K, V] {
+	return &MultiMap[K, V]{
+		tree: New(func(a, b multiMapEntry[K, V]) int {
+			return compare(a.key, b.key)
+		}),
+	}
+}
+
+// Add appends v to the group of values for k.
+func (m *MultiMap[K, V]) Add(k K, v V) {
+	n, existing := m.tree.insert(multiMapEntry[K, V]{key: k})
+	if existing != nil {
+		n = existing
+	}
+	n.Value.values = append(n.Value.values, v)
+}
+
+// Get returns the values for k in the order they were added, nil if
+// there are none.
+func (m *MultiMap[K, V]) Get(k K) []V {
+	n := m.tree.Search(multiMapEntry[K, V]{key: k})
+	if n == nil {
+		return nil
+	}
+	return n.Value.values
+}
+
+// Delete removes k and its entire group of values.
+func (m *MultiMap[K, V]) Delete(k K) bool {
+	return m.tree.Delete(multiMapEntry[K, V]{key: k})
+}
+
+// Len returns the number of distinct keys.
+func (m *MultiMap[K, V]) Len() int {
+	return m.tree.Len()
+}
+
+// Range iterates over the keys in ascending order along with their
+// values.
+func (m *MultiMap[K, V]) Range() func(func(K, []V) bool) {
+	return func(yield func(K, []V) bool) {
+		m.tree.Iterate(InOrder)(func(e multiMapEntry[K, V]) bool {
+			return yield(e.key, e.values)
+		})
+	}
+}
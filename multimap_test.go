@@ -0,0 +1,57 @@
+package rbtree
+
+import (
+	"cmp"
+	"slices"
+	"testing"
+)
+
+func TestMultiMap(t *testing.T) {
+	m := NewMultiMap[string, int](cmp.Compare[string])
+	m.Add("b", 1)
+	m.Add("a", 2)
+	m.Add("b", 3)
+	m.Add("c", 4)
+	m.Add("a", 5)
+	m.Add("b", 6)
+
+	if m.Len() != 3 {
+		t.Errorf("want: 3 got: %d", m.Len())
+	}
+	if got, want := m.Get("b"), []int{1, 3, 6}; !slices.Equal(got, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", got, want)
+	}
+	if got := m.Get("z"); got != nil {
+		t.Errorf("missing key should have no values: %#v", got)
+	}
+
+	var keys []string
+	var groups [][]int
+	m.Range()(func(k string, vs []int) bool {
+		keys = append(keys, k)
+		groups = append(groups, vs)
+		return true
+	})
+	if want := []string{"a", "b", "c"}; !slices.Equal(keys, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", keys, want)
+	}
+	if want := [][]int{{2, 5}, {1, 3, 6}, {4}}; !slices.EqualFunc(groups, want, slices.Equal) {
+		t.Errorf("groups differ:\n%#v\n%#v", groups, want)
+	}
+
+	if !m.Delete("b") {
+		t.Error("failed to delete b")
+	}
+	if m.Delete("b") {
+		t.Error("deleted b twice")
+	}
+	if m.Get("b") != nil || m.Len() != 2 {
+		t.Error("b's group should be gone")
+	}
+
+	// re-adding starts a fresh group
+	m.Add("b", 7)
+	if got, want := m.Get("b"), []int{7}; !slices.Equal(got, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", got, want)
+	}
+}
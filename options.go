@@ -0,0 +1,101 @@
+package rbtree
+
+// Option configures optional behavior of a tree, see New.
+type Option[T any] func(*RBTree[T])
+
+// WithOrderChecks enables a debug check in Insert that verifies the new
+// value compares strictly between its in-order neighbors. A comparator
+// that is not a strict total order will cause an ErrOrderViolation panic
+// on the offending insert instead of silently corrupting the tree.
+//
+// This costs up to two extra comparisons and O(log n) steps per insert.
+func WithOrderChecks[T any]() Option[T] {
+	return func(r *RBTree[T]) {
+		r.orderChecks = true
+	}
+}
+
+// WithLazyDelete enables lazy delete mode. In this mode Delete does not
+// restructure the tree, it only marks the node as deleted (a tombstone).
+// Tombstoned values are invisible to Search, Has, Len and the value
+// iterators, and inserting an equal value revives the existing node. Call
+// Vacuum to physically remove the tombstones in a single rebuild.
+//
+// Node based and rank based APIs such as Min, Max, Ceiling, Successor,
+// Predecessor, Select and Rank work on the physical tree and will see
+// tombstoned nodes until the next Vacuum. DeleteNode always deletes
+// physically.
+func WithLazyDelete[T any]() Option[T] {
+	return func(r *RBTree[T]) {
+		r.lazyDelete = true
+	}
+}
+
+// WithAutoVacuum makes lazy delete mode call Vacuum by itself as soon as
+// a Delete pushes the TombstoneRatio above threshold, keeping the memory
+// held by tombstones bounded. It has no effect without WithLazyDelete.
+func WithAutoVacuum[T any](threshold float64) Option[T] {
+	return func(r *RBTree[T]) {
+		r.autoVacuum = threshold
+	}
+}
+
+// WithIteratorPrealloc makes the traversal iterators allocate their
+// stack or queue up front, sized from the number of values in the tree,
+// instead of growing it with repeated appends. This trades a larger
+// single allocation for fewer of them, see BenchmarkIteratePrealloc.
+func WithIteratorPrealloc[T any]() Option[T] {
+	return func(r *RBTree[T]) {
+		r.preallocIter = true
+	}
+}
+
+// WithDuplicates turns the tree into a multiset that stores values which
+// compare equal instead of rejecting them. Equal values are kept in the
+// order they were inserted. Search, Delete and Has act on any one of the
+// equal values while SearchFirst, Ceiling and GetGroup start from the
+// first of them.
+//
+// This suits comparators that key on a category shared by many values.
+// Bulk operations that require strictly sorted input, such as Rebuild,
+// still do.
+func WithDuplicates[T any]() Option[T] {
+	return func(r *RBTree[T]) {
+		r.duplicates = true
+	}
+}
+
+// WithRootChangeHook registers hook to be called whenever the root node of
+// the tree changes, whether through an insert into an empty tree, a
+// rotation at the root, a delete or a rebuild. old and new are nil when
+// the tree was or became empty. The hook is not called when an operation
+// leaves the root as it was.
+func WithRootChangeHook[T any](hook func(old, new *Node[T])) Option[T] {
+	return func(r *RBTree[T]) {
+		r.rootHook = hook
+	}
+}
+
+// WithAllocator makes the tree acquire all of its nodes from alloc.
+// Nodes are freed when the tree removes them by value, such as through
+// Delete, Drain, ExtractMinN, DeleteRankRange, Subtract, Retain, Vacuum
+// and Rebuild. Any pointers to such nodes that were kept from earlier
+// lookups must not be used afterwards. DeleteNode never frees since the
+// caller still holds the node, nor do the lazy deletes that only mark a
+// node until a Vacuum.
+func WithAllocator[T any](alloc Allocator[T]) Option[T] {
+	return func(r *RBTree[T]) {
+		r.alloc = alloc
+	}
+}
+
+// WithEquality sets the function used to decide whether two values that
+// compare equal are also fully equal, for trees whose comparator only
+// looks at a key. Equal and Diff use it to detect values whose key is
+// unchanged but whose payload differs. Without it values are equal when
+// the comparator returns 0.
+func WithEquality[T any](equal func(a, b T) bool) Option[T] {
+	return func(r *RBTree[T]) {
+		r.equality = equal
+	}
+}
@@ -0,0 +1,58 @@
+package rbtree
+
+// PriorityQueue is a min-priority queue over a tree in multiset mode so
+// values of equal priority are allowed and popped in the order they were
+// pushed. Pushing hands out the node of the value which can later be
+// given to Update to change its priority, as decrease-key in Dijkstra's
+// algorithm needs.
+type PriorityQueue[T any] struct {
+	tree *RBTree[T]
+}
+
+// NewPriorityQueue constructs a PriorityQueue that pops the smallest
+// value according to compare first.
+func NewPriorityQueue[T any](compare func(a, b T) int) *PriorityQueue[T] {
+	return &PriorityQueue[T]{tree: New(compare, WithDuplicates[T]())}
+}
+
+// Len returns the number of values in the queue.
+func (p *PriorityQueue[T]) Len() int {
+	return p.tree.Len()
+}
+
+// Push val onto the queue returning its node for use with Update.
+func (p *PriorityQueue[T]) Push(val T) *Node[T] {
+	return p.tree.Insert(val)
+}
+
+// Peek returns the smallest value without removing it, false if the
+// queue is empty.
+func (p *PriorityQueue[T]) Peek() (T, bool) {
+	n := p.tree.Min()
+	if n == nil {
+		var zero T
+		return zero, false
+	}
+	return n.Value, true
+}
+
+// Pop removes and returns the smallest value, false if the queue is
+// empty. The node of the value is invalid afterwards.
+func (p *PriorityQueue[T]) Pop() (T, bool) {
+	n := p.tree.Min()
+	if n == nil {
+		var zero T
+		return zero, false
+	}
+	p.tree.DeleteNode(n)
+	return n.Value, true
+}
+
+// Update replaces the value of n, which must still be in the queue, with
+// val and moves it to its new position. The node is repositioned rather
+// than replaced so the returned node is n itself and remains valid.
+func (p *PriorityQueue[T]) Update(n *Node[T], val T) *Node[T] {
+	n.Value = val
+	p.tree.Refresh([]*Node[T]{n})
+	return n
+}
@@ -0,0 +1,98 @@
+package rbtree
+
+import (
+	"cmp"
+	"slices"
+	"testing"
+)
+
+func TestPriorityQueue(t *testing.T) {
+	q := NewPriorityQueue(cmp.Compare[int])
+	if _, ok := q.Pop(); ok {
+		t.Error("empty queue should not pop")
+	}
+	if _, ok := q.Peek(); ok {
+		t.Error("empty queue should not peek")
+	}
+
+	nodes := map[int]*Node[int]{}
+	for _, v := range []int{5, 3, 8, 3, 9} {
+		nodes[v] = q.Push(v)
+	}
+	if q.Len() != 5 {
+		t.Errorf("want: 5 got: %d", q.Len())
+	}
+
+	// 9 jumps to the front, 8 to the back
+	if n := q.Update(nodes[9], 1); n != nodes[9] {
+		t.Error("update should keep the node")
+	}
+	q.Update(nodes[8], 10)
+	isRedBlackTree(t, q.tree, q.tree.root)
+
+	if v, _ := q.Peek(); v != 1 {
+		t.Errorf("want: 1 got: %d", v)
+	}
+	var out []int
+	for q.Len() > 0 {
+		v, _ := q.Pop()
+		out = append(out, v)
+	}
+	if want := []int{1, 3, 3, 5, 10}; !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+}
+
+func TestPriorityQueueDijkstra(t *testing.T) {
+	type edge struct {
+		to, weight int
+	}
+	graph := [][]edge{
+		0: {{1, 7}, {2, 9}, {5, 14}},
+		1: {{0, 7}, {2, 10}, {3, 15}},
+		2: {{0, 9}, {1, 10}, {3, 11}, {5, 2}},
+		3: {{1, 15}, {2, 11}, {4, 6}},
+		4: {{3, 6}, {5, 9}},
+		5: {{0, 14}, {2, 2}, {4, 9}},
+	}
+
+	type entry struct {
+		dist, vertex int
+	}
+	q := NewPriorityQueue(func(a, b entry) int {
+		return cmp.Compare(a.dist, b.dist)
+	})
+
+	const unreached = 1 << 30
+	dist := make([]int, len(graph))
+	nodes := make([]*Node[entry], len(graph))
+	for v := range graph {
+		dist[v] = unreached
+		if v == 0 {
+			dist[v] = 0
+		}
+		nodes[v] = q.Push(entry{dist[v], v})
+	}
+
+	updates := 0
+	for q.Len() > 0 {
+		e, _ := q.Pop()
+		nodes[e.vertex] = nil
+		for _, edge := range graph[e.vertex] {
+			if nodes[edge.to] == nil || e.dist+edge.weight >= dist[edge.to] {
+				continue
+			}
+			dist[edge.to] = e.dist + edge.weight
+			nodes[edge.to] = q.Update(nodes[edge.to], entry{dist[edge.to], edge.to})
+			updates++
+		}
+	}
+
+	if want := []int{0, 7, 9, 20, 20, 11}; !slices.Equal(dist, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", dist, want)
+	}
+	// 5 and 3 are first reached on a longer path and later decreased
+	if updates <= len(graph)-1 {
+		t.Errorf("expected some decrease-key updates beyond the first reach, got %d", updates)
+	}
+}
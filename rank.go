@@ -0,0 +1,297 @@
+package rbtree
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Select finds the node with the given 0-based rank, meaning the k-th
+// smallest value in the tree. Returns nil if k is out of range.
+func (r *RBTree[T]) Select(k int) *Node[T] {
+	if k < 0 || k >= r.root.size {
+		return nil
+	}
+
+	current := r.root
+	for current != r.nil {
+		leftSize := current.left.size
+		if k < leftSize {
+			current = current.left
+		} else if k > leftSize {
+			k -= leftSize + 1
+			current = current.right
+		} else {
+			return current
+		}
+	}
+
+	return nil
+}
+
+// SelectFromEnd finds the node holding the k-th largest value, 0-based,
+// so SelectFromEnd(0) is the maximum. Returns nil if k is out of range.
+func (r *RBTree[T]) SelectFromEnd(k int) *Node[T] {
+	if k < 0 {
+		return nil
+	}
+	return r.Select(r.root.size - 1 - k)
+}
+
+// Rank returns the number of values in the tree that are less than
+// val. If val is in the tree this is its 0-based index in sorted order.
+func (r *RBTree[T]) Rank(val T) int {
+	rank := 0
+	current := r.root
+	for current != r.nil {
+		test := r.compare(val, current.Value)
+		if test < 0 {
+			current = current.left
+		} else if test > 0 {
+			rank += current.left.size + 1
+			current = current.right
+		} else if r.duplicates {
+			// equal values may also be to the left
+			current = current.left
+		} else {
+			return rank + current.left.size
+		}
+	}
+
+	return rank
+}
+
+// rankAfter returns the number of values in the tree that are less than
+// or equal to val.
+func (r *RBTree[T]) rankAfter(val T) int {
+	rank := 0
+	current := r.root
+	for current != r.nil {
+		test := r.compare(val, current.Value)
+		if test < 0 {
+			current = current.left
+		} else if test > 0 || r.duplicates {
+			// equal values may also be to the right
+			rank += current.left.size + 1
+			current = current.right
+		} else {
+			return rank + current.left.size + 1
+		}
+	}
+
+	return rank
+}
+
+// Count returns the number of values in the inclusive range [lo, hi]
+// in O(log n) using the size augmentation. If lo > hi it returns 0.
+// Lazily deleted values are not counted, the size augmentation includes
+// them so while the tree holds any the range is walked instead in
+// O(log n + k) for k values in range.
+func (r *RBTree[T]) Count(lo, hi T) int {
+	if r.compare(lo, hi) > 0 {
+		return 0
+	}
+	if r.tombstones == 0 {
+		return r.rankAfter(hi) - r.Rank(lo)
+	}
+
+	count := 0
+	r.ascend(lo, func(n *Node[T]) bool {
+		if r.compare(n.Value, hi) > 0 {
+			return false
+		}
+		count++
+		return true
+	})
+	return count
+}
+
+// RangeMedian returns the median of the values in the inclusive range
+// [lo, hi] in O(log n), the upper of the two middle values when the range
+// holds an even number of them. Returns false if the range is empty.
+// Like Count it skips lazily deleted values, walking the range while the
+// tree holds any.
+func (r *RBTree[T]) RangeMedian(lo, hi T) (T, bool) {
+	count := r.Count(lo, hi)
+	if count == 0 {
+		var zero T
+		return zero, false
+	}
+	if r.tombstones == 0 {
+		return r.Select(r.Rank(lo) + count/2).Value, true
+	}
+
+	var median *Node[T]
+	skip := count / 2
+	r.ascend(lo, func(n *Node[T]) bool {
+		if skip == 0 {
+			median = n
+			return false
+		}
+		skip--
+		return true
+	})
+	return median.Value, true
+}
+
+// ApproxRank estimates Rank(val) without reading the size augmentation,
+// as a tree without it would have to. The size of every subtree skipped
+// while descending is estimated from its black height alone: a subtree
+// with k black nodes on each path down holds between 2^k-1 and
+// 2^(2k+1)-1 nodes and the estimate takes the geometric mean.
+//
+// If k is the black height of the whole tree then ApproxRank(val)+1 is
+// within a factor of 2^((k+1)/2) of Rank(val)+1. Lazily deleted values
+// are counted. This costs O(log^2 n).
+func (r *RBTree[T]) ApproxRank(val T) int {
+	estimate := func(n *Node[T]) float64 {
+		if n == r.nil {
+			return 0
+		}
+		k := 0
+		for ; n != r.nil; n = n.left {
+			if n.getColor() == black {
+				k++
+			}
+		}
+		return math.Exp2(1.5*float64(k)+0.5) - 1
+	}
+
+	rank := 0.0
+	current := r.root
+	for current != r.nil {
+		test := r.compare(val, current.Value)
+		if test < 0 || (test == 0 && r.duplicates) {
+			current = current.left
+		} else if test > 0 {
+			rank += estimate(current.left) + 1
+			current = current.right
+		} else {
+			rank += estimate(current.left)
+			break
+		}
+	}
+
+	return int(math.Round(rank))
+}
+
+// Partition splits the values of the tree in ascending order into n
+// contiguous sorted partitions whose sizes differ by at most one, the
+// larger ones first. When n exceeds Len the trailing partitions are
+// empty. The partitions share a single backing array but can't grow into
+// each other. An n that is not positive is treated as 1 and returns all
+// values as a single partition.
+func (r *RBTree[T]) Partition(n int) [][]T {
+	n = max(n, 1)
+
+	vals := r.Flatten()
+	base, extra := len(vals)/n, len(vals)%n
+	partitions := make([][]T, n)
+	for i := range partitions {
+		size := base
+		if i < extra {
+			size++
+		}
+		partitions[i] = vals[:size:size]
+		vals = vals[size:]
+	}
+	return partitions
+}
+
+// DeleteRankRange deletes all values with a rank in [i, j) returning
+// the number of values that were deleted. The indices are clamped to
+// the range of the tree.
+func (r *RBTree[T]) DeleteRankRange(i, j int) int {
+	i = max(i, 0)
+	j = min(j, r.root.size)
+	if i >= j {
+		return 0
+	}
+
+	count := j - i
+	n := r.Select(i)
+	for k := 0; k < count; k++ {
+		// deleting does not move nodes around so the successor
+		// can be safely looked up first
+		next := r.Successor(n)
+		r.DeleteNode(n)
+		r.freeNode(n)
+		n = next
+	}
+
+	return count
+}
+
+// Before reports whether node a comes before node b in sorted order.
+// The order is determined from the tree structure alone without calling
+// the comparator. Returns false if either node is nil.
+func (r *RBTree[T]) Before(a, b *Node[T]) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	return r.nodeRank(a) < r.nodeRank(b)
+}
+
+// nodeRank computes the 0-based rank of n by walking up to the root.
+func (r *RBTree[T]) nodeRank(n *Node[T]) int {
+	rank := n.left.size
+	for ; n.getParent() != nil; n = n.getParent() {
+		if n == n.getParent().right {
+			rank += n.getParent().left.size + 1
+		}
+	}
+	return rank
+}
+
+// Sample returns a uniformly random value from the tree in O(log n)
+// using the subtree sizes to pick a random rank. Returns false if the
+// tree is empty.
+func (r *RBTree[T]) Sample(rng *rand.Rand) (T, bool) {
+	if r.Len() == 0 {
+		var zero T
+		return zero, false
+	}
+
+	for {
+		// rejecting tombstones keeps the distribution uniform over the
+		// live values
+		n := r.Select(rng.Intn(r.root.size))
+		if !n.isTombstone() {
+			return n.Value, true
+		}
+	}
+}
+
+// SampleN returns n distinct uniformly random values from the tree,
+// sampled without replacement. If n >= Len() every value is returned in
+// a random order.
+//
+// This performs a partial Fisher-Yates shuffle over the ranks, only
+// tracking the positions that were swapped, so it costs O(n log m).
+func (r *RBTree[T]) SampleN(n int, rng *rand.Rand) []T {
+	if n <= 0 {
+		return nil
+	}
+	if n >= r.Len() || r.tombstones > 0 {
+		all := r.Flatten()
+		rng.Shuffle(len(all), func(i, j int) { all[i], all[j] = all[j], all[i] })
+		return all[:min(n, len(all))]
+	}
+
+	size := r.root.size
+	swapped := make(map[int]int, n)
+	at := func(i int) int {
+		if v, ok := swapped[i]; ok {
+			return v
+		}
+		return i
+	}
+
+	out := make([]T, n)
+	for i := range out {
+		j := i + rng.Intn(size-i)
+		picked := at(j)
+		swapped[j] = at(i)
+		out[i] = r.Select(picked).Value
+	}
+	return out
+}
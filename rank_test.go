@@ -0,0 +1,494 @@
+package rbtree
+
+import (
+	"cmp"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestSelectRank(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	inserts := rand.Perm(50)
+	for _, v := range inserts {
+		tree.Insert(v * 2)
+	}
+	isRedBlackTree(t, tree, tree.root)
+
+	if tree.Len() != 50 {
+		t.Errorf("want: 50 got: %d", tree.Len())
+	}
+	for i := 0; i < 50; i++ {
+		if n := tree.Select(i); n == nil || n.Value != i*2 {
+			t.Errorf("select %d wrong: %#v", i, n)
+		}
+		if rank := tree.Rank(i * 2); rank != i {
+			t.Errorf("rank of %d want: %d got: %d", i*2, i, rank)
+		}
+		// missing values rank where they would be inserted
+		if rank := tree.Rank(i*2 + 1); rank != i+1 {
+			t.Errorf("rank of %d want: %d got: %d", i*2+1, i+1, rank)
+		}
+	}
+	if tree.Select(-1) != nil || tree.Select(50) != nil {
+		t.Error("out of range select should return nil")
+	}
+}
+
+func TestDeleteRankRange(t *testing.T) {
+	newTree := func() *RBTree[int] {
+		tree := New(cmp.Compare[int])
+		for _, v := range rand.Perm(20) {
+			tree.Insert(v)
+		}
+		return tree
+	}
+
+	tests := []struct {
+		name  string
+		i, j  int
+		count int
+	}{
+		{"Middle", 5, 10, 5},
+		{"Start", 0, 3, 3},
+		{"End", 17, 20, 3},
+		{"All", 0, 20, 20},
+		{"Clamped", -5, 100, 20},
+		{"ClampedEnd", 18, 100, 2},
+		{"Empty", 4, 4, 0},
+		{"Inverted", 10, 5, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tree := newTree()
+			want := tree.Flatten()
+			lo, hi := max(test.i, 0), min(test.j, len(want))
+			if lo < hi {
+				want = slices.Delete(want, lo, hi)
+			}
+
+			if count := tree.DeleteRankRange(test.i, test.j); count != test.count {
+				t.Errorf("want: %d got: %d", test.count, count)
+			}
+			isRedBlackTree(t, tree, tree.root)
+
+			if out := tree.Flatten(); !slices.Equal(out, want) {
+				t.Errorf("slices differ:\n%#v\n%#v", out, want)
+			}
+			for i, v := range want {
+				if rank := tree.Rank(v); rank != i {
+					t.Errorf("rank of %d want: %d got: %d", v, i, rank)
+				}
+			}
+		})
+	}
+}
+
+func TestNodeSize(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i)
+	}
+
+	// The shape after ordered inserts (see the PreOrder iterator test):
+	//
+	//        4
+	//      /   \
+	//     2     6
+	//    / \   / \
+	//   1   3 5   8
+	//            / \
+	//           7   9
+	//                \
+	//                 10
+	want := map[int]int{4: 10, 2: 3, 6: 6, 8: 4, 9: 2, 1: 1, 10: 1}
+	for v, size := range want {
+		if got := tree.Search(v).Size(); got != size {
+			t.Errorf("size of %d want: %d got: %d", v, size, got)
+		}
+	}
+
+	if tree.nil.Size() != 0 {
+		t.Error("sentinel should have size 0")
+	}
+	var n *Node[int]
+	if n.Size() != 0 {
+		t.Error("nil node should have size 0")
+	}
+}
+
+func TestBefore(t *testing.T) {
+	compares := 0
+	tree := New(func(a, b int) int {
+		compares++
+		return cmp.Compare(a, b)
+	})
+	var nodes []*Node[int]
+	for _, v := range rand.Perm(30) {
+		nodes = append(nodes, tree.Insert(v))
+	}
+	compares = 0
+
+	for _, a := range nodes {
+		for _, b := range nodes {
+			want := a.Value < b.Value
+			if got := tree.Before(a, b); got != want {
+				t.Errorf("Before(%d, %d) want: %t got: %t", a.Value, b.Value, want, got)
+			}
+		}
+	}
+
+	if compares != 0 {
+		t.Errorf("comparator was called %d times", compares)
+	}
+
+	missing := tree.Search(100)
+	if tree.Before(missing, nodes[0]) || tree.Before(nodes[0], missing) || tree.Before(nil, nil) {
+		t.Error("a nil node should never come before another")
+	}
+}
+
+func TestSelectFromEnd(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for _, v := range rand.Perm(30) {
+		tree.Insert(v)
+	}
+
+	if tree.SelectFromEnd(0) != tree.Max() {
+		t.Error("SelectFromEnd(0) should be the max")
+	}
+
+	reversed := tree.Flatten()
+	slices.Reverse(reversed)
+	for k, want := range reversed {
+		if n := tree.SelectFromEnd(k); n == nil || n.Value != want {
+			t.Errorf("k=%d want: %d got: %#v", k, want, n)
+		}
+	}
+
+	if tree.SelectFromEnd(-1) != nil || tree.SelectFromEnd(30) != nil {
+		t.Error("out of range should return nil")
+	}
+}
+
+func TestSample(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tree := New(cmp.Compare[int])
+	if _, ok := tree.Sample(rng); ok {
+		t.Error("sampling an empty tree should fail")
+	}
+
+	for i := 0; i < 10; i++ {
+		tree.Insert(i)
+	}
+
+	const samples = 20000
+	counts := make(map[int]int)
+	for i := 0; i < samples; i++ {
+		v, ok := tree.Sample(rng)
+		if !ok {
+			t.Fatal("sample failed")
+		}
+		counts[v]++
+	}
+
+	expect := samples / tree.Len()
+	for i := 0; i < 10; i++ {
+		if counts[i] < expect*8/10 || counts[i] > expect*12/10 {
+			t.Errorf("value %d sampled %d times, expected around %d", i, counts[i], expect)
+		}
+	}
+}
+
+func TestSampleN(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tree := New(cmp.Compare[int])
+	for i := 0; i < 20; i++ {
+		tree.Insert(i)
+	}
+
+	t.Run("Distinct", func(t *testing.T) {
+		for n := 0; n <= 20; n++ {
+			out := tree.SampleN(n, rng)
+			if len(out) != n {
+				t.Errorf("want: %d values got: %d", n, len(out))
+			}
+			seen := make(map[int]bool)
+			for _, v := range out {
+				if seen[v] || !tree.Has(v) {
+					t.Errorf("bad sample %d in %#v", v, out)
+				}
+				seen[v] = true
+			}
+		}
+	})
+
+	t.Run("All", func(t *testing.T) {
+		out := tree.SampleN(50, rng)
+		slices.Sort(out)
+		if want := tree.Flatten(); !slices.Equal(out, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, want)
+		}
+	})
+
+	t.Run("Uniform", func(t *testing.T) {
+		const trials = 10000
+		counts := make(map[int]int)
+		for i := 0; i < trials; i++ {
+			for _, v := range tree.SampleN(5, rng) {
+				counts[v]++
+			}
+		}
+
+		// each value has a 5/20 chance of being picked per trial
+		expect := trials * 5 / 20
+		for i := 0; i < 20; i++ {
+			if counts[i] < expect*8/10 || counts[i] > expect*12/10 {
+				t.Errorf("value %d sampled %d times, expected around %d", i, counts[i], expect)
+			}
+		}
+	})
+}
+
+func TestApproxRank(t *testing.T) {
+	for _, size := range []int{1, 10, 1000, 20000} {
+		tree := New(cmp.Compare[int])
+		for _, v := range rand.Perm(size) {
+			tree.Insert(v * 2)
+		}
+
+		k := tree.blackHeight(tree.root)
+		bound := math.Exp2(float64(k+1) / 2)
+		// odd values are missing from the tree
+		for v := -1; v <= size*2; v++ {
+			approx, exact := float64(tree.ApproxRank(v)+1), float64(tree.Rank(v)+1)
+			ratio := max(approx/exact, exact/approx)
+			if ratio > bound {
+				t.Errorf("size %d value %d approx: %v exact: %v exceeds the bound %v", size, v, approx-1, exact-1, bound)
+			}
+		}
+	}
+
+	if got := New(cmp.Compare[int]).ApproxRank(5); got != 0 {
+		t.Errorf("want: 0 got: %d", got)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for _, v := range rand.Perm(10) {
+		tree.Insert(v)
+	}
+
+	tests := []struct {
+		n     int
+		sizes []int
+	}{
+		{1, []int{10}},
+		{3, []int{4, 3, 3}},
+		{5, []int{2, 2, 2, 2, 2}},
+		{10, []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+		{12, []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0}},
+	}
+
+	for _, test := range tests {
+		partitions := tree.Partition(test.n)
+		var sizes, all []int
+		for _, p := range partitions {
+			sizes = append(sizes, len(p))
+			all = append(all, p...)
+		}
+		if !slices.Equal(sizes, test.sizes) {
+			t.Errorf("n %d sizes differ:\n%#v\n%#v", test.n, sizes, test.sizes)
+		}
+		if want := tree.Flatten(); !slices.Equal(all, want) {
+			t.Errorf("n %d slices differ:\n%#v\n%#v", test.n, all, want)
+		}
+	}
+
+	// appending to one partition must not clobber the next
+	partitions := tree.Partition(2)
+	_ = append(partitions[0], -1)
+	if partitions[1][0] != 5 {
+		t.Errorf("want: 5 got: %d", partitions[1][0])
+	}
+
+	if got := New(cmp.Compare[int]).Partition(2); len(got) != 2 || len(got[0]) != 0 || len(got[1]) != 0 {
+		t.Errorf("expected two empty partitions: %#v", got)
+	}
+
+	for _, n := range []int{0, -3} {
+		got := tree.Partition(n)
+		if len(got) != 1 || !slices.Equal(got[0], tree.Flatten()) {
+			t.Errorf("n %d expected a single partition: %#v", n, got)
+		}
+	}
+}
+
+func TestRangeMedian(t *testing.T) {
+	unique := New(cmp.Compare[int])
+	multi := New(cmp.Compare[int], WithDuplicates[int]())
+	lazy := New(cmp.Compare[int], WithLazyDelete[int]())
+	for _, v := range rand.Perm(300) {
+		unique.Insert(v * 3)
+		multi.Insert(v / 4)
+		lazy.Insert(v * 3)
+	}
+	for v := 0; v < 300; v += 5 {
+		lazy.Delete(v * 3)
+	}
+
+	for _, tree := range []*RBTree[int]{unique, multi, lazy} {
+		vals := tree.Flatten()
+		for i := 0; i < 200; i++ {
+			lo, hi := rand.Intn(1000)-50, rand.Intn(1000)-50
+			var window []int
+			for _, v := range vals {
+				if v >= lo && v <= hi {
+					window = append(window, v)
+				}
+			}
+
+			if got := tree.Count(lo, hi); got != len(window) {
+				t.Errorf("count [%d, %d] want: %d got: %d", lo, hi, len(window), got)
+			}
+			median, ok := tree.RangeMedian(lo, hi)
+			if len(window) == 0 {
+				if ok {
+					t.Errorf("[%d, %d] expected an empty range, got: %d", lo, hi, median)
+				}
+				continue
+			}
+			if want := window[len(window)/2]; !ok || median != want {
+				t.Errorf("[%d, %d] want: %d got: %d %t", lo, hi, want, median, ok)
+			}
+		}
+	}
+
+	t.Run("Tombstones", func(t *testing.T) {
+		tree := New(cmp.Compare[int], WithLazyDelete[int]())
+		for i := 0; i < 5; i++ {
+			tree.Insert(i)
+		}
+		tree.Delete(2)
+		if got := tree.Count(0, 4); got != 4 {
+			t.Errorf("want: 4 got: %d", got)
+		}
+		if median, ok := tree.RangeMedian(0, 4); !ok || median != 3 {
+			t.Errorf("want: 3 got: %d %t", median, ok)
+		}
+		if _, ok := tree.RangeMedian(2, 2); ok {
+			t.Error("expected the deleted value to be an empty range")
+		}
+	})
+}
+
+// recomputeSizes rebuilds the size augmentation of the subtree rooted at
+// n from scratch, the naive alternative to keeping it up to date.
+func recomputeSizes[T any](tree *RBTree[T], n *Node[T]) int {
+	if n == tree.nil {
+		return 0
+	}
+	n.size = recomputeSizes(tree, n.left) + recomputeSizes(tree, n.right) + 1
+	return n.size
+}
+
+func TestNodeSizeRandomOps(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	tree := New(cmp.Compare[int])
+	for op := 0; op < 20000; op++ {
+		v := rng.Intn(2000)
+		switch rng.Intn(5) {
+		case 0, 1:
+			if !tree.Has(v) {
+				tree.Insert(v)
+			}
+		case 2:
+			tree.Delete(v)
+		case 3:
+			if n := tree.Ceiling(v); n != nil {
+				tree.DeleteNode(n)
+			}
+		case 4:
+			tree.DeleteRankRange(v, v+rng.Intn(3))
+		}
+
+		if op%1000 != 0 {
+			continue
+		}
+		isRedBlackTree(t, tree, tree.root)
+		vals := tree.Flatten()
+		for i, v := range vals {
+			if n := tree.Select(i); n == nil || n.Value != v || tree.Rank(v) != i {
+				t.Fatalf("op %d: select and rank of %d disagree with index %d", op, v, i)
+			}
+		}
+		if tree.Len() != len(vals) {
+			t.Fatalf("op %d: want: %d got: %d", op, len(vals), tree.Len())
+		}
+	}
+}
+
+// BenchmarkSizeAugmentation measures the ancestor walk that keeps the
+// sizes up to date on every insert and delete. InsertDelete is the whole
+// operation, AncestorWalk only the two walks it contains (one up for the
+// insert, one up for the delete) and Recompute the naive alternative of
+// recomputing every size after each operation.
+func BenchmarkSizeAugmentation(b *testing.B) {
+	newTree := func(rng *rand.Rand) *RBTree[int] {
+		tree := New(cmp.Compare[int])
+		for _, v := range rng.Perm(1 << 12) {
+			tree.Insert(v * 2)
+		}
+		return tree
+	}
+
+	b.Run("InsertDelete", func(b *testing.B) {
+		rng := rand.New(rand.NewSource(1))
+		tree := newTree(rng)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			v := rng.Intn(1<<13) | 1
+			tree.Insert(v)
+			tree.Delete(v)
+		}
+	})
+
+	b.Run("AncestorWalk", func(b *testing.B) {
+		rng := rand.New(rand.NewSource(1))
+		tree := newTree(rng)
+		var leaves []*Node[int]
+		iterator := inOrderIter[int]{tree: tree}
+		iterator.nodes(func(n *Node[int]) bool {
+			if n.left == tree.nil && n.right == tree.nil {
+				leaves = append(leaves, n)
+			}
+			return true
+		})
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			n := leaves[rng.Intn(len(leaves))]
+			for p := n.getParent(); p != nil; p = p.getParent() {
+				p.size++
+			}
+			for p := n.getParent(); p != nil; p = p.getParent() {
+				p.size--
+			}
+		}
+	})
+
+	b.Run("Recompute", func(b *testing.B) {
+		rng := rand.New(rand.NewSource(1))
+		tree := newTree(rng)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			v := rng.Intn(1<<13) | 1
+			tree.Insert(v)
+			recomputeSizes(tree, tree.root)
+			tree.Delete(v)
+			recomputeSizes(tree, tree.root)
+		}
+	})
+}
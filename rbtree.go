@@ -4,11 +4,25 @@
 // The API allows for nodes to be held on to externally to
 // encourage special indexing concerns, but care must be
 // taken in those advanced sorts of used cases.
+//
+// A node returned by Insert, Search or any other lookup holds the same
+// value for as long as that value is in the tree. Rebalancing only
+// relinks nodes, and deleting a value whose node has two children moves
+// the node of its successor into its place instead of copying the
+// successor's value over, so no delete changes the value of another
+// node. Only the node of the deleted value itself is unlinked. In lazy
+// delete mode that node stays in the tree as a tombstone until the next
+// Vacuum, so inserting an equal value again revives the very same node.
+// The exceptions are Rebuild and RebuildFromShape, after which no
+// earlier node may be used, and WithAllocator which can hand the node of
+// a deleted value out again.
 package rbtree
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+	"unsafe"
 )
 
 type color bool
@@ -18,133 +32,562 @@ const (
 	red   color = true
 )
 
+func (c color) String() string {
+	if c == red {
+		return "red"
+	}
+	return "black"
+}
+
 // RBTree is a generic red black tree.
 type RBTree[T any] struct {
 	root    *Node[T]
 	nil     *Node[T]
 	compare func(a, b T) int
+
+	orderChecks bool
+
+	lazyDelete bool
+	tombstones int
+	// autoVacuum is the tombstone ratio that triggers a Vacuum, 0 is off
+	autoVacuum float64
+
+	preallocIter bool
+
+	duplicates bool
+
+	rootHook func(old, new *Node[T])
+
+	// alloc provides the nodes, nil means the heap
+	alloc Allocator[T]
+
+	// equivalence is the primary comparator of NewWeak
+	equivalence func(a, b T) int
+	// equality compares whole values, nil means the comparator decides
+	equality func(a, b T) bool
+
+	// max caches the node holding the largest value, nil when unknown
+	max *Node[T]
+
+	// seq is the sequence number of the last inserted node
+	seq uint64
+
+	stats OpStats
 }
 
-// New constructs a red black tree, note that compare can never return 0.
-func New[T any](compare func(a, b T) int) *RBTree[T] {
-	nil := &Node[T]{color: black}
-	return &RBTree[T]{compare: compare, root: nil, nil: nil}
+// New constructs a red black tree, note that compare can never return 0
+// unless WithDuplicates is used.
+func New[T any](compare func(a, b T) int, opts ...Option[T]) *RBTree[T] {
+	nil := &Node[T]{}
+	r := &RBTree[T]{compare: compare, root: nil, nil: nil}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 // Node for the red black tree, only exposes it's value publicly
 // to lessen the possibility of accidental manipulation, granted
 // the value is enough to make a mess.
+//
+// The color and the tombstone flag are packed into the two low bits of
+// the parent pointer, which are always zero since nodes are word
+// aligned, so the node carries no separate word for them. See tag for
+// the encoding. Unpacking costs a few instructions on every parent
+// access, BenchmarkNodeMemory shows the memory saved.
 type Node[T any] struct {
-	color color
+	// link is the parent pointer tagged with the color and tombstone
+	// flags, use getParent, getColor and isTombstone to read it
+	link  unsafe.Pointer
+	left  *Node[T]
+	right *Node[T]
+
+	// size is the number of nodes in the subtree rooted at this node,
+	// the sentinel always has a size of 0. Rotations fix the two nodes
+	// they move in O(1). Inserts and deletes walk up the ancestors of the
+	// linked or unlinked node once afterwards. The sizes could be adjusted
+	// during the descent that finds the node instead, but that has to be
+	// undone whenever the descent ends without a change and DeleteNode
+	// starts from a node with no descent at all. See
+	// BenchmarkSizeAugmentation for the cost of the walk.
+	size int
 
-	parent *Node[T]
-	left   *Node[T]
-	right  *Node[T]
+	// aux is external bookkeeping data the tree never inspects
+	aux any
+	// seq is the insertion sequence number
+	seq uint64
 
 	Value T
 }
 
+// SetAux attaches arbitrary metadata to the node that does not take
+// part in ordering.
+//
+// Deleting other values never moves values between nodes so the
+// metadata stays with its value for as long as the node is in the tree.
+// Operations that rebuild the tree from values, like Rebuild, allocate
+// or reset nodes and do not preserve it.
+func (n *Node[T]) SetAux(aux any) {
+	n.aux = aux
+}
+
+// GetAux returns the metadata attached with SetAux.
+func (n *Node[T]) GetAux() any {
+	return n.aux
+}
+
+// Seq returns the sequence number assigned to the node when it was
+// inserted. Sequence numbers start at 1 and increase with every insert
+// into the tree so they record insertion order.
+func (n *Node[T]) Seq() uint64 {
+	return n.seq
+}
+
+// LastSeq returns the sequence number of the most recently inserted
+// node, 0 if nothing was ever inserted. See Node.Seq.
+func (r *RBTree[T]) LastSeq() uint64 {
+	return r.seq
+}
+
+// Size returns the number of nodes in the subtree rooted at n,
+// including n itself. The sentinel and nil nodes have a size of 0.
+func (n *Node[T]) Size() int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+const (
+	// redBit and tombstoneBit are the flags tagged into Node.link
+	redBit       = 1
+	tombstoneBit = 2
+	flagBits     = redBit | tombstoneBit
+)
+
+// orphan stands in for the parent of a node without one whenever that
+// node has flags set, tagging a nil pointer would produce an invalid
+// pointer value such as 0x1.
+var orphan uint64
+
+// tag combines parent and flags into a tagged pointer. Both a node and
+// orphan are at least 4 byte aligned so the tagged pointer still points
+// into the same allocation and keeps it alive.
+func tag[T any](parent *Node[T], flags uintptr) unsafe.Pointer {
+	p := unsafe.Pointer(parent)
+	if flags == 0 {
+		return p
+	}
+	if p == nil {
+		p = unsafe.Pointer(&orphan)
+	}
+	return unsafe.Add(p, flags)
+}
+
+func (n *Node[T]) flags() uintptr {
+	return uintptr(n.link) & flagBits
+}
+
+func (n *Node[T]) getParent() *Node[T] {
+	p := unsafe.Add(n.link, -int(n.flags()))
+	if p == unsafe.Pointer(&orphan) {
+		return nil
+	}
+	return (*Node[T])(p)
+}
+
+func (n *Node[T]) setParent(parent *Node[T]) {
+	n.link = tag(parent, n.flags())
+}
+
 func (n *Node[T]) getColor() color {
 	if n == nil {
 		return black
 	}
-	return n.color
+	return n.flags()&redBit != 0
+}
+
+func (n *Node[T]) setColor(c color) {
+	flags := n.flags() &^ redBit
+	if c == red {
+		flags |= redBit
+	}
+	n.link = tag(n.getParent(), flags)
+}
+
+func (n *Node[T]) isTombstone() bool {
+	return n.flags()&tombstoneBit != 0
+}
+
+func (n *Node[T]) setTombstone(tombstone bool) {
+	flags := n.flags() &^ tombstoneBit
+	if tombstone {
+		flags |= tombstoneBit
+	}
+	n.link = tag(n.getParent(), flags)
 }
 
 // Insert val and return a pointer to the Node that was inserted
 // for indexing purposes.
 func (r *RBTree[T]) Insert(val T) *Node[T] {
+	n, existing := r.insert(val)
+	if existing != nil {
+		panic("duplicate value")
+	}
+	return n
+}
+
+// InsertReportingStructuralChange is like Insert but also reports whether
+// rebalancing rotated any nodes. When it returns false the insert only
+// recolored nodes so every existing node kept its parent and children,
+// letting external structural indexes skip their fixups.
+func (r *RBTree[T]) InsertReportingStructuralChange(val T) (*Node[T], bool) {
+	rotations := r.stats.Rotations
+	n := r.Insert(val)
+	return n, r.stats.Rotations != rotations
+}
+
+// ErrInvalidRotation occurs when a rotation is attempted on a node that
+// is missing the child that would take its place.
+var ErrInvalidRotation = errors.New("invalid rotation: required child is the sentinel")
+
+// ErrPanic wraps a panic that was recovered by one of the Try methods.
+// These come from the comparator or from a tree whose structure was
+// corrupted.
+type ErrPanic struct {
+	Value any
+}
+
+func (e ErrPanic) Error() string {
+	return fmt.Sprintf("recovered panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it was an error.
+func (e ErrPanic) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// ErrDuplicate is returned by InsertChecked when the comparator
+// reports a value as equal to one already in the tree. Both values are
+// kept so that the offending pair can be logged, which makes it much
+// easier to track down a faulty comparator.
+type ErrDuplicate[T any] struct {
+	New      T
+	Existing T
+}
+
+func (e ErrDuplicate[T]) Error() string {
+	return fmt.Sprintf("duplicate value: %v compares equal to existing value %v", e.New, e.Existing)
+}
+
+// InsertChecked is like Insert but returns an ErrDuplicate instead of
+// panicking when val compares equal to a value already in the tree.
+// The tree is left unchanged when an error is returned.
+func (r *RBTree[T]) InsertChecked(val T) (*Node[T], error) {
+	n, existing := r.insert(val)
+	if existing != nil {
+		return nil, ErrDuplicate[T]{New: val, Existing: existing.Value}
+	}
+	return n, nil
+}
+
+// TryInsert is like InsertChecked but also recovers any panic that
+// occurs during the insert and returns it as an ErrPanic, making it
+// safe to use with untrusted comparators.
+//
+// A panic raised by the comparator leaves the tree unchanged, however a
+// panic raised while rebalancing means the tree was already corrupt and
+// it should not be used further.
+func (r *RBTree[T]) TryInsert(val T) (n *Node[T], err error) {
+	err = try(func() error {
+		var err error
+		n, err = r.InsertChecked(val)
+		return err
+	})
+	return n, err
+}
+
+// TryDelete is like Delete but recovers any panic that occurs during the
+// delete and returns it as an ErrPanic. The same caveats as TryInsert
+// apply.
+func (r *RBTree[T]) TryDelete(val T) (deleted bool, err error) {
+	err = try(func() error {
+		deleted = r.Delete(val)
+		return nil
+	})
+	return deleted, err
+}
+
+// try runs fn converting any panic into an ErrPanic.
+func try(fn func() error) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = ErrPanic{Value: recovered}
+		}
+	}()
+	return fn()
+}
+
+// insert val into the tree, if a node comparing equal to val is
+// already present the tree is left untouched and that node is returned
+// as the second return value instead.
+func (r *RBTree[T]) insert(val T) (inserted, existing *Node[T]) {
 	if r.root == r.nil {
 		// recolor from red to black to avoid fixup call
-		r.root = &Node[T]{
-			Value: val,
-			color: black,
-			left:  r.nil,
-			right: r.nil,
+		r.setRoot(r.newNode(val))
+		r.root.setColor(black)
+		r.stats.Inserts++
+		return r.root, nil
+	}
+
+	// fast path for values arriving in ascending order, they always end
+	// up as the right child of the current maximum
+	top := r.maximumCached()
+	if test := r.compare(val, top.Value); test > 0 || (test == 0 && r.duplicates) {
+		inserted = r.newNode(val)
+		r.attach(inserted, top, 1)
+		r.stats.Inserts++
+		return inserted, nil
+	}
+
+	parent, test, existing := r.locate(val)
+	if existing != nil {
+		if existing.isTombstone() {
+			// revive the lazily deleted node in place
+			existing.setTombstone(false)
+			existing.Value = val
+			r.seq++
+			existing.seq = r.seq
+			r.tombstones--
+			r.stats.Inserts++
+			return existing, nil
 		}
-		return r.root
+		return nil, existing
 	}
 
+	inserted = r.newNode(val)
+	r.attach(inserted, parent, test)
+	r.stats.Inserts++
+	return inserted, nil
+}
+
+// locate descends from the root looking for val. If a node comparing
+// equal to val is found it's returned as existing, otherwise parent is
+// the node val would be a child of and test tells which side.
+func (r *RBTree[T]) locate(val T) (parent *Node[T], test int, existing *Node[T]) {
 	current := r.root
-	insert := &Node[T]{
-		Value: val,
-		color: red,
-		left:  r.nil,
-		right: r.nil,
-	}
 	for current != r.nil {
-		test := r.compare(val, current.Value)
+		parent = current
+		test = r.compare(val, current.Value)
 		if test < 0 {
-			if current.left == r.nil {
-				insert.parent = current
-				current.left = insert
-				break
-			}
 			current = current.left
-		} else if test > 0 {
-			if current.right == r.nil {
-				insert.parent = current
-				current.right = insert
-				break
-			}
+		} else if test > 0 || r.duplicates {
+			// equal values go after the existing ones
 			current = current.right
 		} else {
-			panic("duplicate value")
+			return nil, 0, current
 		}
 	}
 
-	r.insertFixup(insert)
-	return insert
+	return parent, test, nil
+}
+
+// attach links the red leaf n underneath parent on the side indicated by
+// test and rebalances the tree.
+func (r *RBTree[T]) attach(n, parent *Node[T], test int) {
+	n.setParent(parent)
+	if test < 0 {
+		parent.left = n
+	} else {
+		parent.right = n
+	}
+
+	if r.orderChecks {
+		r.checkOrder(n)
+	}
+
+	// only move the cached maximum once the node is known to stay
+	if test >= 0 && parent == r.max {
+		r.max = n
+	}
+
+	// every ancestor gains a descendant, see the size field of Node
+	for p := n.getParent(); p != nil; p = p.getParent() {
+		p.size++
+	}
+
+	r.insertFixup(n)
+}
+
+// newNode creates a red leaf holding val, assigning it the next sequence
+// number.
+func (r *RBTree[T]) newNode(val T) *Node[T] {
+	r.seq++
+	n := r.allocNode()
+	*n = Node[T]{
+		Value: val,
+		size:  1,
+		seq:   r.seq,
+		left:  r.nil,
+		right: r.nil,
+	}
+	n.setColor(red)
+	return n
+}
+
+// ErrOrderViolation is the panic value used when WithOrderChecks is
+// enabled and a freshly inserted value is not strictly between its
+// neighbors according to the comparator. Prev and Next are nil when the
+// value has no neighbor on that side.
+type ErrOrderViolation[T any] struct {
+	Prev  *T
+	Value T
+	Next  *T
+}
+
+func (e ErrOrderViolation[T]) Error() string {
+	prev, next := "<none>", "<none>"
+	if e.Prev != nil {
+		prev = fmt.Sprintf("%v", *e.Prev)
+	}
+	if e.Next != nil {
+		next = fmt.Sprintf("%v", *e.Next)
+	}
+	return fmt.Sprintf("comparator is not a strict total order: %v was placed between %s and %s", e.Value, prev, next)
+}
+
+// checkOrder verifies a freshly linked leaf against its neighbors, on
+// failure the leaf is unlinked again and the tree is left unchanged.
+func (r *RBTree[T]) checkOrder(n *Node[T]) {
+	prev, next := r.Predecessor(n), r.Successor(n)
+	limit := 0
+	if r.duplicates {
+		limit = 1
+	}
+	if (prev == nil || r.compare(prev.Value, n.Value) < limit) && (next == nil || r.compare(n.Value, next.Value) < limit) {
+		return
+	}
+
+	if n == n.getParent().left {
+		n.getParent().left = r.nil
+	} else {
+		n.getParent().right = r.nil
+	}
+
+	err := ErrOrderViolation[T]{Value: n.Value}
+	if prev != nil {
+		v := prev.Value
+		err.Prev = &v
+	}
+	if next != nil {
+		v := next.Value
+		err.Next = &v
+	}
+	panic(err)
+}
+
+// recolor sets the color of n, counting it as a recoloring if it changed.
+func (r *RBTree[T]) recolor(n *Node[T], c color) {
+	if n.getColor() != c {
+		n.setColor(c)
+		r.stats.Recolorings++
+	}
 }
 
 func (r *RBTree[T]) insertFixup(check *Node[T]) {
-	for check.parent.getColor() == red {
-		grandParent := check.parent.parent
+	for check.getParent().getColor() == red {
+		grandParent := check.getParent().getParent()
 
 		// Check direction of our parent (left or right)
-		if check.parent == grandParent.left {
+		if check.getParent() == grandParent.left {
 			uncle := grandParent.right   // uncle will be on the right
 			if uncle.getColor() == red { // right uncle is red
-				check.parent.color = black
-				uncle.color = black
-				grandParent.color = red
+				r.recolor(check.getParent(), black)
+				r.recolor(uncle, black)
+				r.recolor(grandParent, red)
 				check = grandParent
 			} else {
-				if check == check.parent.right { // right uncle black, triangle case
-					check = check.parent
+				if check == check.getParent().right { // right uncle black, triangle case
+					check = check.getParent()
 					r.rotateLeft(check)
 				}
 				// right uncle black, line case
-				check.parent.color = black
-				check.parent.parent.color = red
-				r.rotateRight(check.parent.parent)
+				r.recolor(check.getParent(), black)
+				r.recolor(check.getParent().getParent(), red)
+				r.rotateRight(check.getParent().getParent())
 			}
 		} else {
 			uncle := grandParent.left    // uncle will be on the left
 			if uncle.getColor() == red { // left uncle is red
-				check.parent.color = black
-				uncle.color = black
-				grandParent.color = red
+				r.recolor(check.getParent(), black)
+				r.recolor(uncle, black)
+				r.recolor(grandParent, red)
 				check = grandParent
 			} else {
-				if check == check.parent.left { // left uncle black, triangle case
-					check = check.parent
+				if check == check.getParent().left { // left uncle black, triangle case
+					check = check.getParent()
 					r.rotateRight(check)
 				}
 				// left uncle black, line case
-				check.parent.color = black
-				check.parent.parent.color = red
-				r.rotateLeft(check.parent.parent)
+				r.recolor(check.getParent(), black)
+				r.recolor(check.getParent().getParent(), red)
+				r.rotateLeft(check.getParent().getParent())
 			}
 		}
 	}
 
-	r.root.color = black
+	r.recolor(r.root, black)
 }
 
 // Delete a value. This is the equivalent of DeleteNode(Search(val))
+//
+// In lazy delete mode the node is only marked as deleted, see
+// WithLazyDelete.
 func (r *RBTree[T]) Delete(val T) bool {
-	return r.DeleteNode(r.Search(val))
+	return r.delete(r.Search(val))
+}
+
+// DeleteFirst is like Delete but when the tree holds several values
+// comparing equal to val, see WithDuplicates, it deletes the first one in
+// order.
+func (r *RBTree[T]) DeleteFirst(val T) bool {
+	return r.delete(r.SearchFirst(val))
+}
+
+// DeleteLast is like Delete but when the tree holds several values
+// comparing equal to val, see WithDuplicates, it deletes the last one in
+// order.
+func (r *RBTree[T]) DeleteLast(val T) bool {
+	return r.delete(r.SearchLast(val))
+}
+
+// delete removes n honoring lazy delete mode.
+func (r *RBTree[T]) delete(n *Node[T]) bool {
+	if n == nil {
+		return false
+	}
+	if !r.lazyDelete {
+		r.DeleteNode(n)
+		r.freeNode(n)
+		return true
+	}
+
+	n.setTombstone(true)
+	r.tombstones++
+	r.stats.Deletes++
+	if r.autoVacuum > 0 && r.TombstoneRatio() > r.autoVacuum {
+		r.Vacuum()
+	}
+	return true
+}
+
+// MustDelete deletes val and panics if it's not present, for use by
+// callers that consider a missing value a broken invariant.
+func (r *RBTree[T]) MustDelete(val T) {
+	if !r.Delete(val) {
+		panic(fmt.Sprintf("value not found: %v", val))
+	}
 }
 
 // DeleteNode deletes the provided node, this provides an easy
@@ -154,9 +597,28 @@ func (r *RBTree[T]) DeleteNode(n *Node[T]) bool {
 	if n == nil {
 		return false
 	}
+	if n.isTombstone() {
+		n.setTombstone(false)
+		r.tombstones--
+	} else {
+		r.stats.Deletes++
+	}
+	if n == r.max {
+		r.max = r.Predecessor(n)
+	}
 
 	var odd *Node[T]
-	originalColor := n.color
+	originalColor := n.getColor()
+
+	// every ancestor of the node that is physically unlinked loses one
+	// descendant, in case 3 this is the minimum of the right subtree
+	removed := n
+	if n.left != r.nil && n.right != r.nil {
+		removed = r.minimum(n.right)
+	}
+	for p := removed.getParent(); p != nil; p = p.getParent() {
+		p.size--
+	}
 
 	if n.left == r.nil {
 		// case 1: left child nil
@@ -168,28 +630,26 @@ func (r *RBTree[T]) DeleteNode(n *Node[T]) bool {
 		r.transplant(n, odd)
 	} else {
 		// case 3: neither nil
-		minimum := n.right
-		for minimum.left != r.nil {
-			minimum = minimum.left
-		}
+		minimum := removed
 
-		originalColor = minimum.color
+		originalColor = minimum.getColor()
 		odd = minimum.right
 
-		if minimum.parent == n {
+		if minimum.getParent() == n {
 			if odd != nil {
-				odd.parent = minimum
+				odd.setParent(minimum)
 			}
 		} else {
 			r.transplant(minimum, minimum.right)
 			minimum.right = n.right
-			minimum.right.parent = minimum
+			minimum.right.setParent(minimum)
 		}
 
 		r.transplant(n, minimum)
 		minimum.left = n.left
-		minimum.left.parent = minimum
-		minimum.color = n.color
+		minimum.left.setParent(minimum)
+		minimum.setColor(n.getColor())
+		minimum.size = n.size
 	}
 
 	if originalColor == black {
@@ -199,85 +659,104 @@ func (r *RBTree[T]) DeleteNode(n *Node[T]) bool {
 	return true
 }
 
+// setRoot makes n the root of the tree, calling the root change hook if
+// the root actually changed.
+func (r *RBTree[T]) setRoot(n *Node[T]) {
+	old := r.root
+	r.root = n
+	if old == n || r.rootHook == nil {
+		return
+	}
+
+	// the sentinel is never handed out
+	if old == r.nil {
+		old = nil
+	}
+	if n == r.nil {
+		n = nil
+	}
+	r.rootHook(old, n)
+}
+
 func (r *RBTree[T]) transplant(u, v *Node[T]) {
-	if u.parent == nil {
-		r.root = v
-	} else if u == u.parent.left {
-		u.parent.left = v
+	if u.getParent() == nil {
+		r.setRoot(v)
+	} else if u == u.getParent().left {
+		u.getParent().left = v
 	} else {
-		u.parent.right = v
+		u.getParent().right = v
 	}
 
-	v.parent = u.parent
+	v.setParent(u.getParent())
 }
 
 func (r *RBTree[T]) deleteFixup(n *Node[T]) {
 	for n != r.root && n.getColor() == black {
-		if n == n.parent.left {
-			sibling := n.parent.right
+		if n == n.getParent().left {
+			sibling := n.getParent().right
 
 			// case 1: sibling is red
 			if sibling.getColor() == red {
-				sibling.color = black
-				n.parent.color = red
-				r.rotateLeft(n.parent)
-				sibling = n.parent.right
+				r.recolor(sibling, black)
+				r.recolor(n.getParent(), red)
+				r.rotateLeft(n.getParent())
+				sibling = n.getParent().right
 			}
 
 			// case 2: sibling has two black descendants
 			if sibling.left.getColor() == black && sibling.right.getColor() == black {
-				sibling.color = red
-				n = n.parent
+				r.recolor(sibling, red)
+				n = n.getParent()
 			} else {
 				// case 3
 				if sibling.right.getColor() == black {
-					sibling.left.color = black
-					sibling.color = red
+					r.recolor(sibling.left, black)
+					r.recolor(sibling, red)
 					r.rotateRight(sibling)
-					sibling = n.parent.right
+					sibling = n.getParent().right
 				}
 
 				// case 4
-				sibling.color = n.parent.color
-				n.parent.color = black
-				sibling.right.color = black
-				r.rotateLeft(n.parent)
+				r.recolor(sibling, n.getParent().getColor())
+				r.recolor(n.getParent(), black)
+				r.recolor(sibling.right, black)
+				r.rotateLeft(n.getParent())
 				n = r.root
 			}
 		} else {
-			sibling := n.parent.left
+			sibling := n.getParent().left
 
 			// case 1: sibling is red
 			if sibling.getColor() == red {
-				sibling.color = black
-				n.parent.color = red
-				r.rotateRight(n.parent)
-				sibling = n.parent.left
+				r.recolor(sibling, black)
+				r.recolor(n.getParent(), red)
+				r.rotateRight(n.getParent())
+				sibling = n.getParent().left
 			}
 
 			// case 2: sibling has two black descendants
 			if sibling.right.getColor() == black && sibling.left.getColor() == black {
-				sibling.color = red
-				n = n.parent
+				r.recolor(sibling, red)
+				n = n.getParent()
 			} else {
 				// case 3
 				if sibling.left.getColor() == black {
-					sibling.right.color = black
-					sibling.color = red
+					r.recolor(sibling.right, black)
+					r.recolor(sibling, red)
 					r.rotateLeft(sibling)
-					sibling = n.parent.left
+					sibling = n.getParent().left
 				}
 
 				// case 4
-				sibling.color = n.parent.color
-				n.parent.color = black
-				sibling.left.color = black
-				r.rotateRight(n.parent)
+				r.recolor(sibling, n.getParent().getColor())
+				r.recolor(n.getParent(), black)
+				r.recolor(sibling.left, black)
+				r.rotateRight(n.getParent())
 				n = r.root
 			}
 		}
 	}
-	n.color = black
+	r.recolor(n, black)
 }
 
 // Search for a node in the tree, returns nil if not found
@@ -289,6 +768,12 @@ func (r *RBTree[T]) Search(val T) *Node[T] {
 			current = current.left
 		} else if test > 0 {
 			current = current.right
+		} else if current.isTombstone() {
+			// other equal values may still be live
+			if r.duplicates {
+				return r.searchFirst(val, r.compare)
+			}
+			return nil
 		} else {
 			return current
 		}
@@ -297,69 +782,352 @@ func (r *RBTree[T]) Search(val T) *Node[T] {
 	return nil
 }
 
+// SearchFirst is like Search but when the tree holds several values
+// comparing equal to val, see WithDuplicates, it returns the node of the
+// first one in order.
+func (r *RBTree[T]) SearchFirst(val T) *Node[T] {
+	return r.searchFirst(val, r.compare)
+}
+
+// searchFirst finds the first live node comparing equal to val using
+// compare, which must order the tree at least as coarsely as r.compare.
+func (r *RBTree[T]) searchFirst(val T, compare func(a, b T) int) *Node[T] {
+	var found *Node[T]
+	current := r.root
+	for current != r.nil {
+		test := compare(val, current.Value)
+		if test < 0 {
+			current = current.left
+		} else if test > 0 {
+			current = current.right
+		} else {
+			found = current
+			current = current.left
+		}
+	}
+
+	for found != nil && found.isTombstone() {
+		found = r.next(found)
+		if found != nil && compare(val, found.Value) != 0 {
+			return nil
+		}
+	}
+	return found
+}
+
+// SearchLast is like SearchFirst but returns the node of the last value
+// comparing equal to val in order.
+func (r *RBTree[T]) SearchLast(val T) *Node[T] {
+	var found *Node[T]
+	current := r.root
+	for current != r.nil {
+		test := r.compare(val, current.Value)
+		if test < 0 {
+			current = current.left
+		} else if test > 0 {
+			current = current.right
+		} else {
+			found = current
+			current = current.right
+		}
+	}
+
+	for found != nil && found.isTombstone() {
+		found = r.prev(found)
+		if found != nil && r.compare(val, found.Value) != 0 {
+			return nil
+		}
+	}
+	return found
+}
+
+// GetGroup returns every value in the tree comparing equal to val in
+// order. Without WithDuplicates this is at most a single value. For trees
+// made by NewWeak the group is the equivalence class of val under the
+// primary comparator.
+func (r *RBTree[T]) GetGroup(val T) []T {
+	compare := r.compare
+	if r.equivalence != nil {
+		compare = r.equivalence
+	}
+
+	var group []T
+	for n := r.searchFirst(val, compare); n != nil && compare(val, n.Value) == 0; n = r.next(n) {
+		group = append(group, n.Value)
+	}
+	return group
+}
+
+// Ceiling finds the node holding the smallest value >= val, returns nil
+// if there is none.
+func (r *RBTree[T]) Ceiling(val T) *Node[T] {
+	return r.ceiling(val)
+}
+
+// Min returns the node holding the smallest value, nil if the tree is
+// empty.
+func (r *RBTree[T]) Min() *Node[T] {
+	return r.minimum(r.root)
+}
+
+// Max returns the node holding the largest value, nil if the tree is
+// empty.
+func (r *RBTree[T]) Max() *Node[T] {
+	if r.root == r.nil {
+		return nil
+	}
+	return r.maximumCached()
+}
+
+// maximumCached returns the node holding the largest value using the
+// cache when it's known, the tree must not be empty.
+func (r *RBTree[T]) maximumCached() *Node[T] {
+	if r.max == nil {
+		r.max = r.maximum(r.root)
+	}
+	return r.max
+}
+
+// ExtractMinN removes up to n of the smallest values from the tree and
+// returns them in ascending order. If there are fewer than n values in
+// the tree all of them are returned.
+func (r *RBTree[T]) ExtractMinN(n int) []T {
+	n = min(n, r.Len())
+	if n <= 0 {
+		return nil
+	}
+
+	out := make([]T, 0, n)
+	for len(out) < n {
+		minimum := r.minimum(r.root)
+		dead := minimum.isTombstone()
+		r.DeleteNode(minimum)
+		if !dead {
+			out = append(out, minimum.Value)
+		}
+		r.freeNode(minimum)
+	}
+	return out
+}
+
+func (r *RBTree[T]) ceiling(val T) *Node[T] {
+	var found *Node[T]
+	current := r.root
+	for current != r.nil {
+		test := r.compare(val, current.Value)
+		if test < 0 || (test == 0 && r.duplicates) {
+			found = current
+			current = current.left
+		} else if test > 0 {
+			current = current.right
+		} else {
+			return current
+		}
+	}
+
+	return found
+}
+
+// minimum finds the left-most node of the subtree rooted at n.
+func (r *RBTree[T]) minimum(n *Node[T]) *Node[T] {
+	if n == r.nil {
+		return nil
+	}
+	for n.left != r.nil {
+		n = n.left
+	}
+	return n
+}
+
+// maximum finds the right-most node of the subtree rooted at n.
+func (r *RBTree[T]) maximum(n *Node[T]) *Node[T] {
+	if n == r.nil {
+		return nil
+	}
+	for n.right != r.nil {
+		n = n.right
+	}
+	return n
+}
+
+// SearchAllSorted looks up every value of sorted, which must be in
+// ascending order, returning the node for each value or nil if it's not
+// found. The tree and the input are walked together in O(n+m).
+func (r *RBTree[T]) SearchAllSorted(sorted []T) []*Node[T] {
+	out := make([]*Node[T], len(sorted))
+	n := r.minimum(r.root)
+	for i, val := range sorted {
+		for n != nil {
+			test := r.compare(val, n.Value)
+			if test < 0 {
+				break
+			} else if test > 0 {
+				n = r.Successor(n)
+			} else {
+				if !n.isTombstone() {
+					out[i] = n
+				}
+				break
+			}
+		}
+		if n == nil {
+			break
+		}
+	}
+
+	return out
+}
+
+// ContainsConsecutive reports whether every value of sorted, which must
+// be in ascending order, is in the tree. After one descent to the first
+// value the tree and the input are walked together, so this costs
+// O(log n + k) where k is the number of values stepped over, and it stops
+// at the first value that's missing. An empty slice is always contained.
+func (r *RBTree[T]) ContainsConsecutive(sorted []T) bool {
+	if len(sorted) == 0 {
+		return true
+	}
+
+	n := r.ceiling(sorted[0])
+	if n != nil && n.isTombstone() {
+		n = r.next(n)
+	}
+	for _, val := range sorted {
+		for n != nil && r.compare(n.Value, val) < 0 {
+			n = r.next(n)
+		}
+		if n == nil || r.compare(n.Value, val) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// FirstGap returns the smallest integer >= start that is not in r, such
+// as the next free id in a tree of ids in use. r must be ordered by the
+// natural order of the integers. The walk starts at the ceiling of start
+// and steps through the run of consecutive values, O(log n + k) for a run
+// of length k. With WithDuplicates repeated values are stepped over.
+func FirstGap(r *RBTree[int], start int) int {
+	n := r.ceiling(start)
+	if n != nil && n.isTombstone() {
+		n = r.next(n)
+	}
+	gap := start
+	for ; n != nil; n = r.next(n) {
+		if n.Value == gap {
+			gap++
+		} else if n.Value != gap-1 {
+			break
+		}
+	}
+	return gap
+}
+
+// Len returns the number of values in the tree. In lazy delete mode
+// values that are marked as deleted are not counted.
+func (r *RBTree[T]) Len() int {
+	return r.root.size - r.tombstones
+}
+
 // Has is a convenience method that is equivalent to Search(val) != nil
 func (r *RBTree[T]) Has(val T) bool {
 	return r.Search(val) != nil
 }
 
+// RotateLeftAt rotates the subtree rooted at n to the left so its right
+// child takes its place. The order of the values and the sizes used by the
+// rank APIs are kept but the colors are not touched, so the red black
+// properties may no longer hold afterwards. Use Verify to inspect the
+// result. Returns ErrInvalidRotation if n has no right child.
+func (r *RBTree[T]) RotateLeftAt(n *Node[T]) error {
+	if n == nil || n == r.nil || n.right == r.nil {
+		return ErrInvalidRotation
+	}
+	r.rotateLeft(n)
+	return nil
+}
+
+// RotateRightAt is the mirror image of RotateLeftAt, the left child of n
+// takes its place. Returns ErrInvalidRotation if n has no left child.
+func (r *RBTree[T]) RotateRightAt(n *Node[T]) error {
+	if n == nil || n == r.nil || n.left == r.nil {
+		return ErrInvalidRotation
+	}
+	r.rotateRight(n)
+	return nil
+}
+
 func (r *RBTree[T]) rotateLeft(n *Node[T]) {
 	if n.right == r.nil {
-		panic("is this possible?")
+		panic(ErrInvalidRotation)
 	}
 
+	r.stats.Rotations++
+
 	// set all the descendants
 	newRoot := n.right
 	n.right = newRoot.left
 	newRoot.left = n
 	if n.right != r.nil {
 		// fix the parent of the newly adopted descendant
-		n.right.parent = n
+		n.right.setParent(n)
 	}
 
 	// fix the parent of the old root
-	if n.parent == nil {
-		r.root = newRoot
-	} else if n.parent.left == n {
-		n.parent.left = newRoot
+	if n.getParent() == nil {
+		r.setRoot(newRoot)
+	} else if n.getParent().left == n {
+		n.getParent().left = newRoot
 	} else {
-		n.parent.right = newRoot
+		n.getParent().right = newRoot
 	}
 
 	// fix new root parent
-	newRoot.parent = n.parent
+	newRoot.setParent(n.getParent())
 
 	// fix the old root parent
-	n.parent = newRoot
+	n.setParent(newRoot)
+
+	// newRoot now spans what n used to
+	newRoot.size = n.size
+	n.size = n.left.size + n.right.size + 1
 }
 
 func (r *RBTree[T]) rotateRight(n *Node[T]) {
-	if n.left == nil {
-		panic("is this possible?")
+	if n.left == r.nil {
+		panic(ErrInvalidRotation)
 	}
 
+	r.stats.Rotations++
+
 	// set all the descendants
 	newRoot := n.left
 	n.left = newRoot.right
 	newRoot.right = n
 	if n.left != r.nil {
 		// fix the parent of the newly adopted descendant
-		n.left.parent = n
+		n.left.setParent(n)
 	}
 
 	// fix the parent of the old root
-	if n.parent == nil {
-		r.root = newRoot
-	} else if n.parent.right == n {
-		n.parent.right = newRoot
+	if n.getParent() == nil {
+		r.setRoot(newRoot)
+	} else if n.getParent().right == n {
+		n.getParent().right = newRoot
 	} else {
-		n.parent.left = newRoot
+		n.getParent().left = newRoot
 	}
 
 	// fix new root parent
-	newRoot.parent = n.parent
+	newRoot.setParent(n.getParent())
 
 	// fix the old root parent
-	n.parent = newRoot
+	n.setParent(newRoot)
+
+	// newRoot now spans what n used to
+	newRoot.size = n.size
+	n.size = n.left.size + n.right.size + 1
 }
 
 // Successor looks up the successor to the given node.
@@ -378,10 +1146,10 @@ func (t *RBTree[T]) Successor(node *Node[T]) *Node[T] {
 		return node
 	}
 
-	succ := node.parent
+	succ := node.getParent()
 	for succ != nil && node == succ.right {
 		node = succ
-		succ = succ.parent
+		succ = succ.getParent()
 	}
 	return succ
 }
@@ -402,14 +1170,32 @@ func (t *RBTree[T]) Predecessor(node *Node[T]) *Node[T] {
 		return node
 	}
 
-	pred := node.parent
+	pred := node.getParent()
 	for pred != nil && node == pred.left {
 		node = pred
-		pred = pred.parent
+		pred = pred.getParent()
 	}
 	return pred
 }
 
+// NeighborsOf returns the values immediately before and after val in
+// the tree without exposing any nodes. The flags report whether each
+// neighbor exists, they are both false when val is not in the tree.
+func (r *RBTree[T]) NeighborsOf(val T) (prev, next T, hasPrev, hasNext bool) {
+	n := r.Search(val)
+	if n == nil {
+		return prev, next, false, false
+	}
+
+	if p := r.prev(n); p != nil {
+		prev, hasPrev = p.Value, true
+	}
+	if s := r.next(n); s != nil {
+		next, hasNext = s.Value, true
+	}
+	return prev, next, hasPrev, hasNext
+}
+
 func (r *RBTree[T]) String() string {
 	var builder strings.Builder
 	builder.WriteString("digraph RBTree {\n")
@@ -424,12 +1210,7 @@ func (r *RBTree[T]) nodeToDot(n *Node[T], builder *strings.Builder) {
 		return
 	}
 
-	color := "black"
-	if n.color == red {
-		color = "red"
-	}
-
-	builder.WriteString(fmt.Sprintf("  \"%p\" [label = \"%v\", color=%s];\n", n, n.Value, color))
+	builder.WriteString(fmt.Sprintf("  \"%p\" [label = \"%v\", color=%s];\n", n, n.Value, n.getColor()))
 
 	if n.left != r.nil {
 		builder.WriteString(fmt.Sprintf("  \"%p\" -> \"%p\";\n", n, n.left))
@@ -2,8 +2,13 @@ package rbtree
 
 import (
 	"cmp"
+	"errors"
 	"math/rand"
+	"runtime"
+	"slices"
+	"strconv"
 	"testing"
+	"unsafe"
 )
 
 func TestRedBlackTreeInserts(t *testing.T) {
@@ -128,9 +133,20 @@ func isRedBlackTree[T any](t *testing.T, tree *RBTree[T], n *Node[T]) bool {
 		return true
 	}
 
+	// Check the size augmentation
+	if n == tree.nil {
+		if n.size != 0 {
+			t.Errorf("Sentinel has size %d", n.size)
+			return false
+		}
+	} else if n.size != n.left.size+n.right.size+1 {
+		t.Errorf("Node %v has size %d, want %d:\n%s", n.Value, n.size, n.left.size+n.right.size+1, tree)
+		return false
+	}
+
 	// Check Red property (Red nodes have only black children)
-	if n.color == red {
-		if (n.left != nil && n.left.color == red) || (n.right != nil && n.right.color == red) {
+	if n.getColor() == red {
+		if (n.left != nil && n.left.getColor() == red) || (n.right != nil && n.right.getColor() == red) {
 			t.Errorf("Red node with red child detected:\n%s", tree)
 			return false
 		}
@@ -162,7 +178,7 @@ func blackHeight[T any](t *testing.T, n *Node[T]) int {
 	if leftHeight != rightHeight {
 		return -1
 	}
-	if n.color == black {
+	if n.getColor() == black {
 		return leftHeight + 1
 	}
 	return leftHeight
@@ -226,3 +242,994 @@ func TestRedBlackTreeSteps(t *testing.T) {
 		}
 	})
 }
+
+func TestRedBlackTreeInsertChecked(t *testing.T) {
+	type pair struct {
+		key  int
+		name string
+	}
+	// a subtly wrong comparator that only looks at part of the value
+	tree := New(func(a, b pair) int { return cmp.Compare(a.key, b.key) })
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		if _, err := tree.InsertChecked(pair{key: i, name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := tree.String()
+
+	n, err := tree.InsertChecked(pair{key: 2, name: "z"})
+	if n != nil {
+		t.Error("expected no node on duplicate")
+	}
+
+	var dupErr ErrDuplicate[pair]
+	if !errors.As(err, &dupErr) {
+		t.Fatalf("expected ErrDuplicate, got: %v", err)
+	}
+	if dupErr.New != (pair{key: 2, name: "z"}) {
+		t.Errorf("new value wrong: %#v", dupErr.New)
+	}
+	if dupErr.Existing != (pair{key: 2, name: "c"}) {
+		t.Errorf("existing value wrong: %#v", dupErr.Existing)
+	}
+
+	if after := tree.String(); after != before {
+		t.Errorf("tree changed on duplicate:\n%s\n%s", before, after)
+	}
+	if got := tree.Search(pair{key: 2}).Value.name; got != "c" {
+		t.Errorf("want: c got: %s", got)
+	}
+	isRedBlackTree(t, tree, tree.root)
+}
+
+func TestRedBlackTreeMinMaxCeiling(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	if tree.Min() != nil || tree.Max() != nil || tree.Ceiling(0) != nil {
+		t.Error("empty tree should have no min, max or ceiling")
+	}
+
+	for _, v := range []int{50, 20, 80, 10, 30, 70, 90} {
+		tree.Insert(v)
+	}
+	if got := tree.Min().Value; got != 10 {
+		t.Errorf("want: %d got: %d", 10, got)
+	}
+	if got := tree.Max().Value; got != 90 {
+		t.Errorf("want: %d got: %d", 90, got)
+	}
+
+	ceilings := map[int]int{0: 10, 10: 10, 11: 20, 45: 50, 50: 50, 89: 90, 90: 90}
+	for val, want := range ceilings {
+		if got := tree.Ceiling(val).Value; got != want {
+			t.Errorf("ceiling of %d want: %d got: %d", val, want, got)
+		}
+	}
+	if tree.Ceiling(91) != nil {
+		t.Error("expected no ceiling past the max")
+	}
+}
+
+func TestRedBlackTreeSearchAllSorted(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 10; i++ {
+		tree.Insert(i * 2)
+	}
+
+	keys := []int{0, 2, 3, 4, 4, 11, 12, 20, 21, 30}
+	out := tree.SearchAllSorted(keys)
+	if len(out) != len(keys) {
+		t.Fatalf("want: %d results got: %d", len(keys), len(out))
+	}
+	for i, key := range keys {
+		want := tree.Search(key)
+		if out[i] != want {
+			t.Errorf("key %d want: %p got: %p", key, want, out[i])
+		}
+	}
+
+	if out := New(cmp.Compare[int]).SearchAllSorted(keys); len(out) != len(keys) || out[1] != nil {
+		t.Errorf("expected all nils from an empty tree: %#v", out)
+	}
+}
+
+func TestRedBlackTreeContainsConsecutive(t *testing.T) {
+	tree := New(cmp.Compare[int], WithLazyDelete[int]())
+	for i := 1; i <= 20; i++ {
+		tree.Insert(i)
+	}
+	tree.Delete(15)
+
+	tests := []struct {
+		name string
+		vals []int
+		want bool
+	}{
+		{"Full", []int{3, 4, 5, 6, 7}, true},
+		{"Everything", []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}, true},
+		{"Repeated", []int{3, 3, 4}, true},
+		{"Sparse", []int{2, 9, 20}, true},
+		{"Empty", nil, true},
+		{"Partial", []int{12, 13, 14, 15, 16}, false},
+		{"Tombstone", []int{15}, false},
+		{"RunsOffTheEnd", []int{19, 20, 21}, false},
+		{"StartsBelow", []int{0, 1, 2}, false},
+		{"Absent", []int{30, 31, 32}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := tree.ContainsConsecutive(test.vals); got != test.want {
+				t.Errorf("want: %t got: %t", test.want, got)
+			}
+		})
+	}
+
+	if New(cmp.Compare[int]).ContainsConsecutive([]int{1}) {
+		t.Error("empty tree contains nothing")
+	}
+}
+
+func TestRedBlackTreeFirstGap(t *testing.T) {
+	dense := New(cmp.Compare[int], WithLazyDelete[int]())
+	for i := 0; i < 1000; i++ {
+		dense.Insert(i)
+	}
+	sparse := New(cmp.Compare[int])
+	for _, v := range []int{-4, -3, 0, 1, 2, 5, 7, 8, 9, 20} {
+		sparse.Insert(v)
+	}
+	multi := New(cmp.Compare[int], WithDuplicates[int]())
+	for _, v := range []int{1, 1, 2, 3, 3, 3, 5, 5} {
+		multi.Insert(v)
+	}
+
+	tests := []struct {
+		name  string
+		tree  *RBTree[int]
+		start int
+		want  int
+	}{
+		{"DenseFromZero", dense, 0, 1000},
+		{"DenseInside", dense, 500, 1000},
+		{"DensePastEnd", dense, 2000, 2000},
+		{"DenseBelow", dense, -5, -5},
+		{"SparseFromZero", sparse, 0, 3},
+		{"SparseInGap", sparse, 3, 3},
+		{"SparseRun", sparse, 7, 10},
+		{"SparseStartInside", sparse, 8, 10},
+		{"SparseNegative", sparse, -4, -2},
+		{"MultiRun", multi, 1, 4},
+		{"MultiStartInside", multi, 3, 4},
+		{"MultiAfterGap", multi, 5, 6},
+		{"Empty", New(cmp.Compare[int]), 42, 42},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := FirstGap(test.tree, test.start); got != test.want {
+				t.Errorf("want: %d got: %d", test.want, got)
+			}
+		})
+	}
+
+	// a deleted value is a gap
+	dense.Delete(321)
+	if got := FirstGap(dense, 0); got != 321 {
+		t.Errorf("want: 321 got: %d", got)
+	}
+	dense.Delete(0)
+	if got := FirstGap(dense, 0); got != 0 {
+		t.Errorf("want: 0 got: %d", got)
+	}
+}
+
+func TestRedBlackTreeTry(t *testing.T) {
+	poison := 13
+	compare := func(a, b int) int {
+		if a == poison || b == poison {
+			panic("poisoned comparator")
+		}
+		return cmp.Compare(a, b)
+	}
+
+	tree := New(compare)
+	for i := 1; i <= 10; i++ {
+		if _, err := tree.TryInsert(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Duplicate", func(t *testing.T) {
+		_, err := tree.TryInsert(5)
+		var dupErr ErrDuplicate[int]
+		if !errors.As(err, &dupErr) {
+			t.Errorf("expected ErrDuplicate, got: %v", err)
+		}
+	})
+
+	t.Run("InsertComparatorPanic", func(t *testing.T) {
+		n, err := tree.TryInsert(poison)
+		var panicErr ErrPanic
+		if !errors.As(err, &panicErr) || n != nil {
+			t.Fatalf("expected ErrPanic, got: %v", err)
+		}
+		if panicErr.Value != "poisoned comparator" {
+			t.Errorf("wrong panic value: %v", panicErr.Value)
+		}
+		if tree.Len() != 10 {
+			t.Errorf("tree changed size: %d", tree.Len())
+		}
+		isRedBlackTree(t, tree, tree.root)
+	})
+
+	t.Run("DeleteComparatorPanic", func(t *testing.T) {
+		deleted, err := tree.TryDelete(poison)
+		var panicErr ErrPanic
+		if !errors.As(err, &panicErr) || deleted {
+			t.Errorf("expected ErrPanic, got: %v", err)
+		}
+		isRedBlackTree(t, tree, tree.root)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if deleted, err := tree.TryDelete(5); err != nil || !deleted {
+			t.Errorf("delete failed: %t %v", deleted, err)
+		}
+		if deleted, err := tree.TryDelete(5); err != nil || deleted {
+			t.Errorf("delete of missing value failed: %t %v", deleted, err)
+		}
+		isRedBlackTree(t, tree, tree.root)
+	})
+
+	t.Run("InvalidRotation", func(t *testing.T) {
+		leaf := tree.Search(1)
+		for _, rotate := range []func(*Node[int]){tree.rotateLeft, tree.rotateRight} {
+			err := try(func() error {
+				rotate(leaf)
+				return nil
+			})
+			if !errors.Is(err, ErrInvalidRotation) {
+				t.Errorf("expected ErrInvalidRotation, got: %v", err)
+			}
+		}
+		isRedBlackTree(t, tree, tree.root)
+	})
+}
+
+func TestRedBlackTreeExtractMinN(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	inserts := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	rand.Shuffle(len(inserts), func(i, j int) { inserts[i], inserts[j] = inserts[j], inserts[i] })
+	for _, v := range inserts {
+		tree.Insert(v)
+	}
+
+	batches := []struct {
+		n    int
+		want []int
+	}{
+		{3, []int{1, 2, 3}},
+		{0, nil},
+		{-1, nil},
+		{1, []int{4}},
+		{4, []int{5, 6, 7, 8}},
+		{5, []int{9, 10}},
+		{5, nil},
+	}
+	for _, batch := range batches {
+		out := tree.ExtractMinN(batch.n)
+		if !slices.Equal(out, batch.want) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, batch.want)
+		}
+		isRedBlackTree(t, tree, tree.root)
+		if len(out) > 0 && tree.Len() > 0 && tree.Min().Value <= out[len(out)-1] {
+			t.Errorf("residual min %d not greater than extracted %d", tree.Min().Value, out[len(out)-1])
+		}
+	}
+	if tree.Len() != 0 {
+		t.Errorf("want empty tree, got: %d", tree.Len())
+	}
+}
+
+func TestRedBlackTreeAux(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	inserts := rand.Perm(100)
+	for _, v := range inserts {
+		tree.Insert(v).SetAux(v * 10)
+	}
+
+	// deleting nodes with two children splices in their successor which
+	// must not disturb the metadata of the spliced node
+	for _, v := range inserts[:50] {
+		tree.Delete(v)
+		isRedBlackTree(t, tree, tree.root)
+	}
+	for i := 100; i < 150; i++ {
+		tree.Insert(i)
+	}
+
+	for _, v := range inserts[50:] {
+		n := tree.Search(v)
+		if aux, ok := n.GetAux().(int); !ok || aux != v*10 {
+			t.Errorf("node %d lost its aux data: %#v", v, n.GetAux())
+		}
+	}
+	if aux := tree.Search(120).GetAux(); aux != nil {
+		t.Errorf("new node should have no aux data: %#v", aux)
+	}
+}
+
+func TestRedBlackTreeOrderChecks(t *testing.T) {
+	// this comparator claims every distinct value is larger than every
+	// other, breaking antisymmetry
+	broken := func(a, b int) int {
+		if a == b {
+			return 0
+		}
+		return 1
+	}
+
+	tree := New(broken, WithOrderChecks[int]())
+	tree.Insert(1)
+
+	_, err := tree.TryInsert(2)
+	var violation ErrOrderViolation[int]
+	if !errors.As(err, &violation) {
+		t.Fatalf("expected ErrOrderViolation, got: %v", err)
+	}
+	if violation.Value != 2 || violation.Prev == nil || *violation.Prev != 1 || violation.Next != nil {
+		t.Errorf("wrong violation: %v", violation)
+	}
+	if tree.Len() != 1 || tree.Has(2) {
+		t.Error("tree should be unchanged after a violation")
+	}
+	isRedBlackTree(t, tree, tree.root)
+
+	t.Run("Disabled", func(t *testing.T) {
+		tree := New(broken)
+		tree.Insert(1)
+		if _, err := tree.TryInsert(2); err != nil {
+			t.Errorf("expected the broken comparator to go undetected: %v", err)
+		}
+	})
+
+	t.Run("Healthy", func(t *testing.T) {
+		tree := New(cmp.Compare[int], WithOrderChecks[int]())
+		for _, v := range rand.Perm(100) {
+			tree.Insert(v)
+		}
+		isRedBlackTree(t, tree, tree.root)
+	})
+
+	t.Run("InsertAfterViolation", func(t *testing.T) {
+		// 5 claims to be larger than everything in both directions
+		bad := func(a, b int) int {
+			if a != b && (a == 5 || b == 5) {
+				return 1
+			}
+			return cmp.Compare(a, b)
+		}
+		tree := New(bad, WithOrderChecks[int]())
+		for i := 1; i <= 3; i++ {
+			tree.Insert(i)
+		}
+		if _, err := tree.TryInsert(5); err == nil {
+			t.Fatal("expected a violation")
+		}
+
+		// the rejected node must not linger as the cached maximum
+		tree.Insert(10)
+		if got, want := tree.Flatten(), []int{1, 2, 3, 10}; !slices.Equal(got, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", got, want)
+		}
+		if max := tree.Max(); max == nil || max.Value != 10 {
+			t.Errorf("wrong max: %v", max)
+		}
+		isRedBlackTree(t, tree, tree.root)
+	})
+}
+
+func TestRedBlackTreeSeq(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	inserts := rand.Perm(50)
+	nodes := make([]*Node[int], len(inserts))
+	for i, v := range inserts {
+		nodes[i] = tree.Insert(v)
+		if i > 0 && nodes[i].Seq() <= nodes[i-1].Seq() {
+			t.Errorf("sequence did not increase: %d then %d", nodes[i-1].Seq(), nodes[i].Seq())
+		}
+	}
+	if nodes[0].Seq() != 1 {
+		t.Errorf("want: 1 got: %d", nodes[0].Seq())
+	}
+
+	// rotations and deletes of other values must keep the numbers intact
+	for _, v := range inserts[:25] {
+		tree.Delete(v)
+	}
+	for i, v := range inserts[25:] {
+		if got := tree.Search(v).Seq(); got != uint64(i+26) {
+			t.Errorf("value %d want seq: %d got: %d", v, i+26, got)
+		}
+	}
+
+	// a fresh insert keeps counting from where the tree left off and
+	// failed inserts don't use up a number
+	if _, err := tree.InsertChecked(inserts[30]); err == nil {
+		t.Error("expected a duplicate error")
+	}
+	if got := tree.Insert(inserts[0]).Seq(); got != 51 {
+		t.Errorf("want: 51 got: %d", got)
+	}
+}
+
+func TestRedBlackTreeMustDelete(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 5; i++ {
+		tree.Insert(i)
+	}
+
+	tree.MustDelete(3)
+	if tree.Has(3) || tree.Len() != 4 {
+		t.Error("value was not deleted")
+	}
+	isRedBlackTree(t, tree, tree.root)
+
+	defer func() {
+		if recovered := recover(); recovered != "value not found: 3" {
+			t.Errorf("wrong panic: %v", recovered)
+		}
+		if tree.Len() != 4 {
+			t.Error("tree changed")
+		}
+	}()
+	tree.MustDelete(3)
+}
+
+func TestRedBlackTreeNeighborsOf(t *testing.T) {
+	tree := New(cmp.Compare[int], WithLazyDelete[int]())
+	for _, v := range []int{50, 20, 80, 10, 30, 70, 90} {
+		tree.Insert(v)
+	}
+	tree.Delete(70)
+
+	tests := []struct {
+		val              int
+		prev, next       int
+		hasPrev, hasNext bool
+	}{
+		{10, 0, 20, false, true},
+		{30, 20, 50, true, true},
+		{50, 30, 80, true, true},
+		{80, 50, 90, true, true},
+		{90, 80, 0, true, false},
+		{70, 0, 0, false, false},
+		{45, 0, 0, false, false},
+	}
+
+	for _, test := range tests {
+		prev, next, hasPrev, hasNext := tree.NeighborsOf(test.val)
+		if prev != test.prev || next != test.next || hasPrev != test.hasPrev || hasNext != test.hasNext {
+			t.Errorf("neighbors of %d want: %d %d %t %t got: %d %d %t %t", test.val,
+				test.prev, test.next, test.hasPrev, test.hasNext, prev, next, hasPrev, hasNext)
+		}
+	}
+}
+
+func TestRedBlackTreeGetGroup(t *testing.T) {
+	type item struct {
+		category, id int
+	}
+	tree := New(func(a, b item) int {
+		return cmp.Compare(a.category, b.category)
+	}, WithDuplicates[item](), WithOrderChecks[item]())
+
+	for id, category := range []int{2, 1, 2, 3, 2, 1, 2} {
+		tree.Insert(item{category, id})
+	}
+	isRedBlackTree(t, tree, tree.root)
+	if !tree.IsSorted() {
+		t.Error("tree should be sorted")
+	}
+	if tree.Len() != 7 {
+		t.Errorf("want: 7 got: %d", tree.Len())
+	}
+
+	want := []item{{2, 0}, {2, 2}, {2, 4}, {2, 6}}
+	if out := tree.GetGroup(item{category: 2}); !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+	want = []item{{1, 1}, {1, 5}}
+	if out := tree.GetGroup(item{category: 1}); !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+	if out := tree.GetGroup(item{category: 4}); len(out) != 0 {
+		t.Errorf("expected an empty group: %#v", out)
+	}
+
+	if got := tree.SearchFirst(item{category: 2}).Value; got != (item{2, 0}) {
+		t.Errorf("want: %v got: %v", item{2, 0}, got)
+	}
+	if got := tree.Ceiling(item{category: 3}).Value; got != (item{3, 3}) {
+		t.Errorf("want: %v got: %v", item{3, 3}, got)
+	}
+	if got := tree.Rank(item{category: 3}); got != 6 {
+		t.Errorf("want: 6 got: %d", got)
+	}
+
+	tree.DeleteNode(tree.SearchFirst(item{category: 2}))
+	want = []item{{2, 2}, {2, 4}, {2, 6}}
+	if out := tree.GetGroup(item{category: 2}); !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+	isRedBlackTree(t, tree, tree.root)
+
+	t.Run("Unique", func(t *testing.T) {
+		tree := New(cmp.Compare[int])
+		tree.Insert(1)
+		tree.Insert(2)
+		if out := tree.GetGroup(2); !slices.Equal(out, []int{2}) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, []int{2})
+		}
+	})
+}
+
+func TestRedBlackTreeRootChangeHook(t *testing.T) {
+	type change struct {
+		old, new int
+	}
+	var changes []change
+	tree := New(cmp.Compare[int], WithRootChangeHook(func(old, new *Node[int]) {
+		// -1 stands in for an empty tree
+		c := change{-1, -1}
+		if old != nil {
+			c.old = old.Value
+		}
+		if new != nil {
+			c.new = new.Value
+		}
+		changes = append(changes, c)
+	}))
+
+	steps := []struct {
+		name string
+		op   func()
+		want []change
+	}{
+		{"InsertFirst", func() { tree.Insert(1) }, []change{{-1, 1}}},
+		{"InsertNoRotation", func() { tree.Insert(2) }, nil},
+		{"RotateAtRoot", func() { tree.Insert(3) }, []change{{1, 2}}},
+		{"RecolorOnly", func() { tree.Insert(4) }, nil},
+		{"DeleteLeaf", func() { tree.Delete(4) }, nil},
+		{"DeleteRoot", func() { tree.Delete(2) }, []change{{2, 3}}},
+		{"DeleteMissing", func() { tree.Delete(2) }, nil},
+		{"DeleteChild", func() { tree.Delete(1) }, nil},
+		{"DeleteLast", func() { tree.Delete(3) }, []change{{3, -1}}},
+		{"Rebuild", func() { tree.Rebuild([]int{1, 2, 3}) }, []change{{-1, 2}}},
+	}
+
+	for _, step := range steps {
+		changes = nil
+		step.op()
+		if !slices.Equal(changes, step.want) {
+			t.Errorf("%s slices differ:\n%#v\n%#v", step.name, changes, step.want)
+		}
+		if tree.root != tree.nil && changes != nil && changes[len(changes)-1].new != tree.root.Value {
+			t.Errorf("%s hook saw %d but the root is %d", step.name, changes[len(changes)-1].new, tree.root.Value)
+		}
+	}
+}
+
+func TestRedBlackTreeInsertReportingStructuralChange(t *testing.T) {
+	tree := New(cmp.Compare[int])
+
+	steps := []struct {
+		val        int
+		structural bool
+	}{
+		{1, false}, // new root
+		{2, false}, // red child of a black parent
+		{3, true},  // rotate left at the root
+		{4, false}, // red uncle, recolor only
+		{5, true},  // rotate left at 3
+		{6, false}, // red uncle, recolor only
+		{7, true},  // rotate left at 5
+		{8, true},  // recolor then rotate left at the root
+	}
+
+	for _, step := range steps {
+		before := tree.Bracketed()
+		n, structural := tree.InsertReportingStructuralChange(step.val)
+		if n.Value != step.val {
+			t.Errorf("want: %d got: %d", step.val, n.Value)
+		}
+		if structural != step.structural {
+			t.Errorf("inserting %d into %s want: %t got: %t", step.val, before, step.structural, structural)
+		}
+	}
+	isRedBlackTree(t, tree, tree.root)
+}
+
+func TestRedBlackTreeCachedMax(t *testing.T) {
+	checkMax := func(t *testing.T, tree *RBTree[int], step string) {
+		t.Helper()
+		if tree.max != nil && tree.max != tree.maximum(tree.root) {
+			t.Fatalf("%s: cached max %d is stale", step, tree.max.Value)
+		}
+		if tree.Max() != tree.maximum(tree.root) {
+			t.Fatalf("%s: Max disagrees with the tree", step)
+		}
+	}
+
+	tree := New(cmp.Compare[int], WithLazyDelete[int]())
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		// mostly ascending with some stragglers and deletes near the top
+		v := i
+		switch rng.Intn(4) {
+		case 0:
+			v = rng.Intn(i + 1)
+		case 1:
+			if max := tree.Max(); max != nil {
+				tree.DeleteNode(max)
+				checkMax(t, tree, "delete max")
+			}
+		}
+		if !tree.Has(v) {
+			tree.Insert(v)
+		}
+		checkMax(t, tree, "insert")
+	}
+	isRedBlackTree(t, tree, tree.root)
+
+	tree.Delete(tree.Max().Value)
+	tree.Vacuum()
+	checkMax(t, tree, "vacuum")
+	tree.Insert(1 << 20)
+	checkMax(t, tree, "insert after vacuum")
+
+	tree.Mirror()
+	checkMax(t, tree, "mirror")
+	tree.Mirror()
+
+	tree.Rebuild([]int{1, 2, 3})
+	checkMax(t, tree, "rebuild")
+	tree.Insert(4)
+	checkMax(t, tree, "insert after rebuild")
+
+	for _, v := range []int{4, 3, 2, 1} {
+		tree.DeleteNode(tree.Search(v))
+	}
+	if tree.Max() != nil || tree.max != nil {
+		t.Error("empty tree should have no max")
+	}
+	tree.Insert(7)
+	checkMax(t, tree, "insert into empty")
+}
+
+// BenchmarkInsertAscending measures inserts that all land on the right
+// of the current maximum, the shape of time series data.
+func BenchmarkInsertAscending(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		tree := New(cmp.Compare[int])
+		for v := 0; v < 100000; v++ {
+			tree.Insert(v)
+		}
+	}
+}
+
+func TestRedBlackTreeDeleteFirstLast(t *testing.T) {
+	type item struct {
+		key, id int
+	}
+	for _, lazy := range []bool{false, true} {
+		opts := []Option[item]{WithDuplicates[item]()}
+		if lazy {
+			opts = append(opts, WithLazyDelete[item]())
+		}
+		tree := New(func(a, b item) int {
+			return cmp.Compare(a.key, b.key)
+		}, opts...)
+		for id, key := range []int{1, 2, 2, 2, 2, 3, 2} {
+			tree.Insert(item{key, id})
+		}
+
+		if got := tree.SearchLast(item{key: 2}).Value; got != (item{2, 6}) {
+			t.Errorf("lazy %t want: %v got: %v", lazy, item{2, 6}, got)
+		}
+
+		steps := []struct {
+			first bool
+			want  []item
+		}{
+			{true, []item{{2, 2}, {2, 3}, {2, 4}, {2, 6}}},
+			{false, []item{{2, 2}, {2, 3}, {2, 4}}},
+			{true, []item{{2, 3}, {2, 4}}},
+			{false, []item{{2, 3}}},
+			{true, nil},
+		}
+		for i, step := range steps {
+			var ok bool
+			if step.first {
+				ok = tree.DeleteFirst(item{key: 2})
+			} else {
+				ok = tree.DeleteLast(item{key: 2})
+			}
+			if !ok {
+				t.Errorf("lazy %t step %d deleted nothing", lazy, i)
+			}
+			if out := tree.GetGroup(item{key: 2}); !slices.Equal(out, step.want) {
+				t.Errorf("lazy %t step %d slices differ:\n%#v\n%#v", lazy, i, out, step.want)
+			}
+		}
+
+		if tree.DeleteFirst(item{key: 2}) || tree.DeleteLast(item{key: 2}) {
+			t.Errorf("lazy %t deleted from an empty group", lazy)
+		}
+		want := []item{{1, 0}, {3, 5}}
+		if out := tree.Flatten(); !slices.Equal(out, want) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, want)
+		}
+		if tree.Len() != 2 {
+			t.Errorf("lazy %t want: 2 got: %d", lazy, tree.Len())
+		}
+		isRedBlackTree(t, tree, tree.root)
+	}
+}
+
+func TestRedBlackTreeRotateAt(t *testing.T) {
+	tree := New(cmp.Compare[int])
+	for i := 1; i <= 3; i++ {
+		tree.Insert(i)
+	}
+
+	if err := tree.RotateLeftAt(tree.Search(2)); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "3R(2B(1R,),)", tree.Bracketed(); got != want {
+		t.Errorf("want: %q got: %q", want, got)
+	}
+	// the order and the sizes survive, the colors do not
+	if got := tree.Flatten(); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("slices differ:\n%#v\n%#v", got, []int{1, 2, 3})
+	}
+	for i := 0; i < 3; i++ {
+		if got := tree.Select(i).Value; got != i+1 {
+			t.Errorf("select %d want: %d got: %d", i, i+1, got)
+		}
+	}
+	violations := tree.Verify()
+	if len(violations) == 0 || violations[0].Kind != RootNotBlack {
+		t.Errorf("expected the root to be reported, got: %v", violations)
+	}
+
+	if err := tree.RotateRightAt(tree.root); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "2B(1R,3R)", tree.Bracketed(); got != want {
+		t.Errorf("want: %q got: %q", want, got)
+	}
+	isRedBlackTree(t, tree, tree.root)
+
+	t.Run("Invalid", func(t *testing.T) {
+		leaf := tree.Search(1)
+		for _, err := range []error{
+			tree.RotateLeftAt(leaf), tree.RotateRightAt(leaf),
+			tree.RotateLeftAt(nil), tree.RotateRightAt(tree.nil),
+		} {
+			if !errors.Is(err, ErrInvalidRotation) {
+				t.Errorf("expected ErrInvalidRotation, got: %v", err)
+			}
+		}
+		if want, got := "2B(1R,3R)", tree.Bracketed(); got != want {
+			t.Errorf("want: %q got: %q", want, got)
+		}
+	})
+}
+
+func TestNodeLayout(t *testing.T) {
+	// the flags live in the parent pointer so there is no padding and no
+	// field besides the pointers, the size, aux, seq and the value
+	var i Node[int]
+	var s Node[string]
+	for name, sizes := range map[string][2]uintptr{
+		"int": {unsafe.Sizeof(i), unsafe.Sizeof(i.link) + unsafe.Sizeof(i.left) + unsafe.Sizeof(i.right) +
+			unsafe.Sizeof(i.size) + unsafe.Sizeof(i.aux) + unsafe.Sizeof(i.seq) + unsafe.Sizeof(i.Value)},
+		"string": {unsafe.Sizeof(s), unsafe.Sizeof(s.link) + unsafe.Sizeof(s.left) + unsafe.Sizeof(s.right) +
+			unsafe.Sizeof(s.size) + unsafe.Sizeof(s.aux) + unsafe.Sizeof(s.seq) + unsafe.Sizeof(s.Value)},
+	} {
+		if sizes[0] != sizes[1] {
+			t.Errorf("Node[%s] want: %d bytes got: %d", name, sizes[1], sizes[0])
+		}
+	}
+
+	t.Run("Tagging", func(t *testing.T) {
+		other := &Node[int]{}
+		for _, parent := range []*Node[int]{nil, other} {
+			for _, c := range []color{black, red} {
+				for _, tombstone := range []bool{false, true} {
+					// set in both orders, each setter must keep the others
+					var a, b Node[int]
+					a.setParent(parent)
+					a.setColor(c)
+					a.setTombstone(tombstone)
+					b.setTombstone(tombstone)
+					b.setColor(c)
+					b.setParent(parent)
+					for _, n := range []*Node[int]{&a, &b} {
+						if n.getParent() != parent || n.getColor() != c || n.isTombstone() != tombstone {
+							t.Errorf("want: %p %v %t got: %p %v %t", parent, c, tombstone, n.getParent(), n.getColor(), n.isTombstone())
+						}
+					}
+
+					a.setColor(!c)
+					a.setTombstone(!tombstone)
+					if a.getParent() != parent || a.getColor() != !c || a.isTombstone() != !tombstone {
+						t.Errorf("flipping the flags lost the parent %p", parent)
+					}
+				}
+			}
+		}
+
+		// a node without a parent or flags is the zero value
+		var n Node[int]
+		n.setColor(red)
+		n.setColor(black)
+		if n.link != nil {
+			t.Errorf("want a nil link got: %p", n.link)
+		}
+	})
+
+	// the flags and parents are recovered through every kind of operation
+	rng := rand.New(rand.NewSource(3))
+	tree := New(cmp.Compare[int], WithLazyDelete[int]())
+	for op := 0; op < 5000; op++ {
+		v := rng.Intn(500)
+		switch rng.Intn(7) {
+		case 0, 1:
+			if !tree.Has(v) {
+				tree.Insert(v)
+			}
+		case 2:
+			tree.Delete(v)
+		case 3:
+			if n := tree.Ceiling(v); n != nil {
+				tree.DeleteNode(n)
+			}
+		case 4:
+			if n := tree.Ceiling(v); n != nil && !n.isTombstone() {
+				tree.Refresh([]*Node[int]{n})
+			}
+		case 5:
+			if op%50 == 0 {
+				tree.Vacuum()
+			}
+		case 6:
+			if op%100 == 0 {
+				tree.Mirror()
+				tree.Mirror()
+			}
+		}
+
+		if op%250 != 0 {
+			continue
+		}
+		if violations := tree.Verify(); violations != nil {
+			t.Fatalf("op %d: %v", op, violations)
+		}
+		if tree.nil.getColor() != black || tree.nil.isTombstone() {
+			t.Fatalf("op %d: sentinel flags were set", op)
+		}
+		if tree.root != tree.nil && tree.root.getParent() != nil {
+			t.Fatalf("op %d: root has a parent", op)
+		}
+		tombstones := 0
+		iterator := inOrderIter[int]{tree: tree}
+		iterator.nodes(func(n *Node[int]) bool {
+			if n.isTombstone() {
+				tombstones++
+			}
+			for _, child := range []*Node[int]{n.left, n.right} {
+				if child != tree.nil && child.getParent() != n {
+					t.Fatalf("op %d: parent of %d is not %d", op, child.Value, n.Value)
+				}
+			}
+			return true
+		})
+		if tombstones != tree.tombstones {
+			t.Fatalf("op %d: want: %d tombstones got: %d", op, tree.tombstones, tombstones)
+		}
+	}
+}
+
+// BenchmarkNodeMemory reports the heap used per node of a tree of ints
+// and of short strings.
+func BenchmarkNodeMemory(b *testing.B) {
+	const count = 1 << 16
+	ints := make([]int, count)
+	strs := make([]string, count)
+	for i := range ints {
+		ints[i] = i
+		strs[i] = strconv.Itoa(i)
+	}
+
+	b.Run("Int", func(b *testing.B) {
+		benchNodeMemory(b, cmp.Compare[int], ints)
+	})
+	b.Run("String", func(b *testing.B) {
+		benchNodeMemory(b, cmp.Compare[string], strs)
+	})
+}
+
+func benchNodeMemory[T any](b *testing.B, compare func(a, b T) int, vals []T) {
+	var before, after runtime.MemStats
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		tree := New(compare)
+		for _, v := range vals {
+			tree.Insert(v)
+		}
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.TotalAlloc-before.TotalAlloc)/float64(len(vals)), "B/node")
+		runtime.KeepAlive(tree)
+	}
+}
+
+func TestNodePointerStability(t *testing.T) {
+	for _, lazy := range []bool{false, true} {
+		name := "Physical"
+		var opts []Option[int]
+		if lazy {
+			name = "Lazy"
+			opts = append(opts, WithLazyDelete[int]())
+		}
+
+		t.Run(name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(11))
+			tree := New(cmp.Compare[int], opts...)
+			held := make(map[int]*Node[int])
+			for _, v := range rng.Perm(2000) {
+				held[v*2] = tree.Insert(v * 2)
+			}
+
+			deleted := make(map[int]bool)
+			for op := 0; op < 20000; op++ {
+				if rng.Intn(2) == 0 {
+					// unrelated values never collide with the held ones
+					if v := rng.Intn(4000)*2 + 1; !tree.Has(v) {
+						tree.Insert(v)
+					} else {
+						tree.Delete(v)
+					}
+					continue
+				}
+				v := rng.Intn(2000) * 2
+				if deleted[v] {
+					continue
+				}
+				tree.Delete(v)
+				deleted[v] = true
+			}
+			isRedBlackTree(t, tree, tree.root)
+
+			for v, n := range held {
+				if n.Value != v {
+					t.Fatalf("node of %d now holds %d", v, n.Value)
+				}
+				if got := tree.Search(v); deleted[v] && got != nil {
+					t.Fatalf("deleted %d is still found", v)
+				} else if !deleted[v] && got != n {
+					t.Fatalf("search for %d returned a different node", v)
+				}
+			}
+
+			// a deleted value inserted again only gets its old node back
+			// while it's still a tombstone
+			for v := range deleted {
+				if n := tree.Insert(v); n == held[v] != lazy {
+					t.Fatalf("reinserted %d revived the old node: %t", v, n == held[v])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,407 @@
+package rbtree
+
+import "container/heap"
+
+// ContentHash computes a hash of the values in the tree using the
+// provided per-element hash function. The element hashes are mixed
+// in-order so trees holding the same set of values produce the same
+// hash regardless of their internal shape.
+//
+// Equal hashes do not guarantee equal contents, but differing hashes
+// guarantee differing contents which makes this a cheap pre-check.
+func (r *RBTree[T]) ContentHash(hash func(T) uint64) uint64 {
+	// FNV-1a style accumulation over finalized element hashes
+	h := uint64(14695981039346656037)
+	r.Iterate(InOrder)(func(v T) bool {
+		h ^= mix64(hash(v))
+		h *= 1099511628211
+		return true
+	})
+
+	return h
+}
+
+// mix64 is the splitmix64 finalizer, it spreads poorly distributed
+// hashes (like the identity hash of small integers) across all bits.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// MergeJoin walks both trees in-order simultaneously using compare to
+// match up their values. Values that compare equal are yielded together
+// as a pair, values present in only one of the trees are yielded with a
+// nil on the side of the tree they're missing from.
+//
+// The pointers point at the values stored inside the trees.
+func MergeJoin[T any](a, b *RBTree[T], compare func(a, b T) int) func(func(left, right *T) bool) {
+	return func(yield func(left, right *T) bool) {
+		left, right := a.first(), b.first()
+		for left != nil || right != nil {
+			var test int
+			switch {
+			case left == nil:
+				test = 1
+			case right == nil:
+				test = -1
+			default:
+				test = compare(left.Value, right.Value)
+			}
+
+			var ok bool
+			if test < 0 {
+				ok = yield(&left.Value, nil)
+				left = a.next(left)
+			} else if test > 0 {
+				ok = yield(nil, &right.Value)
+				right = b.next(right)
+			} else {
+				ok = yield(&left.Value, &right.Value)
+				left = a.next(left)
+				right = b.next(right)
+			}
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// Diff compares two versions of a tree, added yields the values in new
+// that are not in old and removed the values in old that are not in new,
+// both in ascending order according to new's comparator. A value whose
+// key is in both but that differs according to new's WithEquality counts
+// as removed from old and added to new. Nodes are never shared between
+// trees so each iterator walks both trees together in O(n+m).
+func Diff[T any](old, new *RBTree[T]) (added, removed func(func(T) bool)) {
+	added = func(yield func(T) bool) {
+		MergeJoin(old, new, new.compare)(func(left, right *T) bool {
+			if right == nil || (left != nil && new.equal(*left, *right)) {
+				return true
+			}
+			return yield(*right)
+		})
+	}
+	removed = func(yield func(T) bool) {
+		MergeJoin(old, new, new.compare)(func(left, right *T) bool {
+			if left == nil || (right != nil && new.equal(*left, *right)) {
+				return true
+			}
+			return yield(*left)
+		})
+	}
+	return added, removed
+}
+
+// Equal reports whether r and other hold the same values. Values are
+// matched up in order by r's comparator and must also be equal according
+// to r's WithEquality, if any. This costs O(n).
+func (r *RBTree[T]) Equal(other *RBTree[T]) bool {
+	if r.Len() != other.Len() {
+		return false
+	}
+	for a, b := r.first(), other.first(); a != nil; a, b = r.next(a), other.next(b) {
+		if r.compare(a.Value, b.Value) != 0 || !r.equal(a.Value, b.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// equal reports whether two values that compare equal are fully equal,
+// see WithEquality.
+func (r *RBTree[T]) equal(a, b T) bool {
+	return r.equality == nil || r.equality(a, b)
+}
+
+// SharedSubtree returns the root of the largest subtree of a that also
+// appears in b with exactly the same shape and values, colors aside, or
+// nil if the trees have no value in common. The returned node belongs to
+// a. Lazily deleted nodes take part like live ones.
+//
+// Nodes are never shared between trees so this is a structural
+// comparison. The subtrees of a are matched bottom-up, a non-leaf node can
+// only match the parent of the nodes its children matched in b, so only
+// leaves are searched for and the cost is O(n log m).
+func SharedSubtree[T any](a, b *RBTree[T]) *Node[T] {
+	var best *Node[T]
+	var match func(x *Node[T]) *Node[T]
+	match = func(x *Node[T]) *Node[T] {
+		if x == a.nil {
+			return nil
+		}
+		left, right := match(x.left), match(x.right)
+		if (x.left != a.nil && left == nil) || (x.right != a.nil && right == nil) {
+			return nil
+		}
+
+		var y *Node[T]
+		switch {
+		case left != nil:
+			y = left.getParent()
+		case right != nil:
+			y = right.getParent()
+		default:
+			for y = b.root; y != b.nil; {
+				test := b.compare(x.Value, y.Value)
+				if test == 0 {
+					break
+				} else if test < 0 {
+					y = y.left
+				} else {
+					y = y.right
+				}
+			}
+			if y == b.nil {
+				return nil
+			}
+		}
+
+		if y == nil || a.compare(x.Value, y.Value) != 0 ||
+			(left == nil) != (y.left == b.nil) || (left != nil && y.left != left) ||
+			(right == nil) != (y.right == b.nil) || (right != nil && y.right != right) {
+			return nil
+		}
+		if best == nil || x.size > best.size {
+			best = x
+		}
+		return y
+	}
+
+	match(a.root)
+	return best
+}
+
+// MergeK walks all trees in-order simultaneously yielding every value of
+// every tree in globally ascending order according to compare, the K-way
+// generalization of MergeJoin. Values that compare equal across trees are
+// all yielded, in the order of the trees in the argument list. This costs
+// O(log k) per value using a min-heap of the trees' positions.
+func MergeK[T any](compare func(a, b T) int, trees ...*RBTree[T]) func(func(T) bool) {
+	return func(yield func(T) bool) {
+		h := &mergeHeap[T]{compare: compare}
+		for i, tree := range trees {
+			if n := tree.first(); n != nil {
+				h.cursors = append(h.cursors, mergeCursor[T]{node: n, tree: i})
+			}
+		}
+		heap.Init(h)
+
+		for len(h.cursors) > 0 {
+			top := &h.cursors[0]
+			if !yield(top.node.Value) {
+				return
+			}
+
+			if top.node = trees[top.tree].next(top.node); top.node == nil {
+				heap.Pop(h)
+			} else {
+				heap.Fix(h, 0)
+			}
+		}
+	}
+}
+
+// mergeCursor is the position of MergeK within one of the trees.
+type mergeCursor[T any] struct {
+	node *Node[T]
+	tree int
+}
+
+// mergeHeap implements heap.Interface ordering cursors by value and then
+// by the position of their tree in the argument list.
+type mergeHeap[T any] struct {
+	compare func(a, b T) int
+	cursors []mergeCursor[T]
+}
+
+func (h *mergeHeap[T]) Len() int { return len(h.cursors) }
+
+func (h *mergeHeap[T]) Less(i, j int) bool {
+	if test := h.compare(h.cursors[i].node.Value, h.cursors[j].node.Value); test != 0 {
+		return test < 0
+	}
+	return h.cursors[i].tree < h.cursors[j].tree
+}
+
+func (h *mergeHeap[T]) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+
+func (h *mergeHeap[T]) Push(x any) { h.cursors = append(h.cursors, x.(mergeCursor[T])) }
+
+func (h *mergeHeap[T]) Pop() any {
+	last := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return last
+}
+
+// UnionCount returns the number of distinct values in r and other
+// together, the Len of their union, by walking both trees in O(n+m)
+// without building anything.
+func (r *RBTree[T]) UnionCount(other *RBTree[T]) int {
+	count := 0
+	MergeJoin(r, other, r.compare)(func(left, right *T) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// IntersectionCount returns the number of values present in both r and
+// other, the Len of their intersection, by walking both trees in O(n+m)
+// without building anything.
+func (r *RBTree[T]) IntersectionCount(other *RBTree[T]) int {
+	count := 0
+	MergeJoin(r, other, r.compare)(func(left, right *T) bool {
+		if left != nil && right != nil {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// Subtract removes every value from r that is also present in other,
+// returning the number of values removed. Both trees are walked together
+// in O(n+m) and the matches are deleted as a batch afterwards.
+func (r *RBTree[T]) Subtract(other *RBTree[T]) int {
+	var doomed []*Node[T]
+	n, o := r.first(), other.first()
+	for n != nil && o != nil {
+		test := r.compare(n.Value, o.Value)
+		if test < 0 {
+			n = r.next(n)
+		} else if test > 0 {
+			o = other.next(o)
+		} else {
+			doomed = append(doomed, n)
+			n = r.next(n)
+			o = other.next(o)
+		}
+	}
+
+	r.deleteSorted(doomed)
+	return len(doomed)
+}
+
+// Retain removes every value from r that is not present in other,
+// leaving r holding the intersection of the two, and returns the number
+// of values removed. Both trees are walked together in O(n+m) and the
+// removals are deleted as a batch afterwards.
+func (r *RBTree[T]) Retain(other *RBTree[T]) int {
+	var doomed []*Node[T]
+	n, o := r.first(), other.first()
+	for n != nil {
+		test := -1
+		if o != nil {
+			test = r.compare(n.Value, o.Value)
+		}
+
+		if test < 0 {
+			doomed = append(doomed, n)
+			n = r.next(n)
+		} else if test > 0 {
+			o = other.next(o)
+		} else {
+			n = r.next(n)
+			o = other.next(o)
+		}
+	}
+
+	r.deleteSorted(doomed)
+	return len(doomed)
+}
+
+// deleteSorted physically deletes the nodes which must be in sorted
+// order. When a large fraction of the tree is being deleted it's cheaper
+// to relink the surviving nodes into a fresh balanced tree than to
+// delete them one at a time, either way the surviving nodes are kept.
+func (r *RBTree[T]) deleteSorted(doomed []*Node[T]) {
+	if len(doomed)*2 < r.root.size {
+		for _, n := range doomed {
+			r.DeleteNode(n)
+			r.freeNode(n)
+		}
+		return
+	}
+
+	live := make([]*Node[T], 0, r.root.size-len(doomed))
+	rest := doomed
+	iterator := inOrderIter[T]{tree: r}
+	iterator.nodes(func(n *Node[T]) bool {
+		if len(rest) > 0 && n == rest[0] {
+			rest = rest[1:]
+			// counted the same way DeleteNode does
+			if n.isTombstone() {
+				r.tombstones--
+			} else {
+				r.stats.Deletes++
+			}
+			return true
+		}
+		live = append(live, n)
+		return true
+	})
+	r.setRoot(r.link(live))
+	for _, n := range doomed {
+		r.freeNode(n)
+	}
+}
+
+// first returns the smallest node that is not a tombstone.
+func (r *RBTree[T]) first() *Node[T] {
+	n := r.minimum(r.root)
+	for n != nil && n.isTombstone() {
+		n = r.Successor(n)
+	}
+	return n
+}
+
+// next returns the successor of n that is not a tombstone.
+func (r *RBTree[T]) next(n *Node[T]) *Node[T] {
+	n = r.Successor(n)
+	for n != nil && n.isTombstone() {
+		n = r.Successor(n)
+	}
+	return n
+}
+
+// last returns the largest node that is not a tombstone.
+func (r *RBTree[T]) last() *Node[T] {
+	n := r.maximum(r.root)
+	for n != nil && n.isTombstone() {
+		n = r.Predecessor(n)
+	}
+	return n
+}
+
+// prev returns the predecessor of n that is not a tombstone.
+func (r *RBTree[T]) prev(n *Node[T]) *Node[T] {
+	n = r.Predecessor(n)
+	for n != nil && n.isTombstone() {
+		n = r.Predecessor(n)
+	}
+	return n
+}
+
+// SymmetricDifference returns a new tree holding the values that are in
+// exactly one of r and other. Both trees are walked together and the
+// result is bulk-built in O(n+m).
+func (r *RBTree[T]) SymmetricDifference(other *RBTree[T]) *RBTree[T] {
+	var vals []T
+	MergeJoin(r, other, r.compare)(func(left, right *T) bool {
+		if left == nil {
+			vals = append(vals, *right)
+		} else if right == nil {
+			vals = append(vals, *left)
+		}
+		return true
+	})
+
+	out := New(r.compare)
+	out.root = out.build(vals, out.allocNode)
+	return out
+}
@@ -0,0 +1,546 @@
+package rbtree
+
+import (
+	"cmp"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func intHash(v int) uint64 {
+	return uint64(v)
+}
+
+func TestContentHash(t *testing.T) {
+	t.Run("SameSetDifferentShape", func(t *testing.T) {
+		ascending := New(cmp.Compare[int])
+		descending := New(cmp.Compare[int])
+		for i := 1; i <= 10; i++ {
+			ascending.Insert(i)
+			descending.Insert(11 - i)
+		}
+
+		if ascending.root.Value == descending.root.Value {
+			t.Errorf("expected trees with different shapes, both rooted at %d", ascending.root.Value)
+		}
+
+		a, b := ascending.ContentHash(intHash), descending.ContentHash(intHash)
+		if a != b {
+			t.Errorf("hashes differ: %x != %x", a, b)
+		}
+	})
+
+	t.Run("DifferentSets", func(t *testing.T) {
+		base := New(cmp.Compare[int])
+		for i := 1; i <= 10; i++ {
+			base.Insert(i)
+		}
+		baseHash := base.ContentHash(intHash)
+
+		for i := 1; i <= 10; i++ {
+			other := New(cmp.Compare[int])
+			for j := 1; j <= 10; j++ {
+				if j != i {
+					other.Insert(j)
+				}
+			}
+			other.Insert(i + 100)
+
+			if h := other.ContentHash(intHash); h == baseHash {
+				t.Errorf("replacing %d did not change the hash: %x", i, h)
+			}
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		a, b := New(cmp.Compare[int]), New(cmp.Compare[int])
+		if a.ContentHash(intHash) != b.ContentHash(intHash) {
+			t.Error("empty trees should hash the same")
+		}
+		b.Insert(0)
+		if a.ContentHash(intHash) == b.ContentHash(intHash) {
+			t.Error("empty tree should differ from a tree holding zero")
+		}
+	})
+}
+
+func TestMergeJoin(t *testing.T) {
+	a, b := New(cmp.Compare[int]), New(cmp.Compare[int])
+	for _, v := range []int{1, 3, 4, 6, 8} {
+		a.Insert(v)
+	}
+	for _, v := range []int{2, 3, 6, 7, 8, 9} {
+		b.Insert(v)
+	}
+
+	type pair struct {
+		left, right int
+	}
+	var out []pair
+	MergeJoin(a, b, cmp.Compare[int])(func(left, right *int) bool {
+		// -1 stands in for a missing side
+		p := pair{-1, -1}
+		if left != nil {
+			p.left = *left
+		}
+		if right != nil {
+			p.right = *right
+		}
+		out = append(out, p)
+		return true
+	})
+
+	want := []pair{
+		{1, -1}, {-1, 2}, {3, 3}, {4, -1}, {6, 6}, {-1, 7}, {8, 8}, {-1, 9},
+	}
+	if !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+
+	t.Run("EarlyTermination", func(t *testing.T) {
+		count := 0
+		MergeJoin(a, b, cmp.Compare[int])(func(left, right *int) bool {
+			count++
+			return count < 3
+		})
+		if count != 3 {
+			t.Errorf("want: 3 got: %d", count)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		count := 0
+		MergeJoin(New(cmp.Compare[int]), b, cmp.Compare[int])(func(left, right *int) bool {
+			if left != nil {
+				t.Error("left should always be nil")
+			}
+			count++
+			return true
+		})
+		if count != 6 {
+			t.Errorf("want: 6 got: %d", count)
+		}
+	})
+}
+
+func TestMergeK(t *testing.T) {
+	type tagged struct {
+		v, tree int
+	}
+	compare := func(a, b tagged) int {
+		return cmp.Compare(a.v, b.v)
+	}
+
+	trees := make([]*RBTree[tagged], 3)
+	var want []tagged
+	for i := range trees {
+		trees[i] = New(compare)
+		for _, v := range rand.Perm(20) {
+			// overlapping ranges with duplicates across trees
+			trees[i].Insert(tagged{v + i*10, i})
+			want = append(want, tagged{v + i*10, i})
+		}
+	}
+	// ties are broken by the order of the trees
+	slices.SortFunc(want, func(a, b tagged) int {
+		if test := cmp.Compare(a.v, b.v); test != 0 {
+			return test
+		}
+		return cmp.Compare(a.tree, b.tree)
+	})
+
+	var out []tagged
+	MergeK(compare, trees...)(func(v tagged) bool {
+		out = append(out, v)
+		return true
+	})
+	if !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+
+	t.Run("EarlyTermination", func(t *testing.T) {
+		count := 0
+		MergeK(compare, trees...)(func(tagged) bool {
+			count++
+			return count < 5
+		})
+		if count != 5 {
+			t.Errorf("want: 5 got: %d", count)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		ints := []*RBTree[int]{New(cmp.Compare[int]), New(cmp.Compare[int]), New(cmp.Compare[int])}
+		ints[1].Insert(2)
+		ints[1].Insert(1)
+		if out := runIterator(MergeK(cmp.Compare[int], ints...)); !slices.Equal(out, []int{1, 2}) {
+			t.Errorf("slices differ:\n%#v\n%#v", out, []int{1, 2})
+		}
+		if out := runIterator(MergeK[int](cmp.Compare[int])); len(out) != 0 {
+			t.Errorf("expected nothing: %#v", out)
+		}
+	})
+}
+
+func TestUnionIntersectionCount(t *testing.T) {
+	for _, overlap := range []int{0, 10, 25, 40} {
+		a, b := New(cmp.Compare[int]), New(cmp.Compare[int])
+		for _, v := range rand.Perm(40) {
+			a.Insert(v)
+			b.Insert(v + 40 - overlap)
+		}
+
+		union, intersection := New(cmp.Compare[int]), New(cmp.Compare[int])
+		for _, tree := range []*RBTree[int]{a, b} {
+			tree.Iterate(InOrder)(func(v int) bool {
+				if !union.Has(v) {
+					union.Insert(v)
+				}
+				if a.Has(v) && b.Has(v) && !intersection.Has(v) {
+					intersection.Insert(v)
+				}
+				return true
+			})
+		}
+
+		if got := a.UnionCount(b); got != union.Len() {
+			t.Errorf("overlap %d union want: %d got: %d", overlap, union.Len(), got)
+		}
+		if got := a.IntersectionCount(b); got != intersection.Len() || got != overlap {
+			t.Errorf("overlap %d intersection want: %d got: %d", overlap, intersection.Len(), got)
+		}
+	}
+
+	empty := New(cmp.Compare[int])
+	if got := empty.UnionCount(empty); got != 0 {
+		t.Errorf("want: 0 got: %d", got)
+	}
+}
+
+func TestSubtract(t *testing.T) {
+	newTree := func(vals ...int) *RBTree[int] {
+		tree := New(cmp.Compare[int])
+		for _, v := range vals {
+			tree.Insert(v)
+		}
+		return tree
+	}
+
+	tests := []struct {
+		name   string
+		a, b   []int
+		want   []int
+		remove int
+	}{
+		{"Overlapping", []int{1, 2, 3, 4, 5, 6, 7, 8}, []int{2, 4, 6, 10}, []int{1, 3, 5, 7, 8}, 3},
+		{"MostlyOverlapping", []int{1, 2, 3, 4, 5, 6, 7, 8}, []int{0, 1, 2, 3, 4, 5, 6, 9}, []int{7, 8}, 6},
+		{"Disjoint", []int{1, 3, 5}, []int{2, 4, 6}, []int{1, 3, 5}, 0},
+		{"Everything", []int{1, 3, 5}, []int{1, 3, 5}, nil, 3},
+		{"EmptyOther", []int{1, 3, 5}, nil, []int{1, 3, 5}, 0},
+		{"EmptyReceiver", nil, []int{1, 3, 5}, nil, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := newTree(test.a...), newTree(test.b...)
+			survivors := make(map[int]*Node[int])
+			for _, v := range test.want {
+				survivors[v] = a.Search(v)
+			}
+
+			if removed := a.Subtract(b); removed != test.remove {
+				t.Errorf("want: %d removed got: %d", test.remove, removed)
+			}
+			isRedBlackTree(t, a, a.root)
+			if out := a.Flatten(); !slices.Equal(out, test.want) {
+				t.Errorf("slices differ:\n%#v\n%#v", out, test.want)
+			}
+			for v, n := range survivors {
+				if a.Search(v) != n {
+					t.Errorf("node for %d was replaced", v)
+				}
+			}
+			if out := b.Flatten(); !slices.Equal(out, newTree(test.b...).Flatten()) {
+				t.Errorf("other was modified: %#v", out)
+			}
+		})
+	}
+}
+
+func TestSymmetricDifference(t *testing.T) {
+	a, b := New(cmp.Compare[int]), New(cmp.Compare[int])
+	for _, v := range rand.Perm(30) {
+		a.Insert(v)
+	}
+	for _, v := range rand.Perm(30) {
+		b.Insert(v + 15)
+	}
+
+	// brute force (A∪B) \ (A∩B)
+	union, intersection := New(cmp.Compare[int]), New(cmp.Compare[int])
+	for _, tree := range []*RBTree[int]{a, b} {
+		tree.Iterate(InOrder)(func(v int) bool {
+			if !union.Has(v) {
+				union.Insert(v)
+			}
+			if a.Has(v) && b.Has(v) && !intersection.Has(v) {
+				intersection.Insert(v)
+			}
+			return true
+		})
+	}
+	union.Subtract(intersection)
+	want := union.Flatten()
+
+	out := a.SymmetricDifference(b)
+	isRedBlackTree(t, out, out.root)
+	if got := out.Flatten(); !slices.Equal(got, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", got, want)
+	}
+	if got := b.SymmetricDifference(a).Flatten(); !slices.Equal(got, want) {
+		t.Errorf("not symmetric:\n%#v\n%#v", got, want)
+	}
+	if out.Len() != 30 {
+		t.Errorf("want: 30 got: %d", out.Len())
+	}
+
+	if got := a.SymmetricDifference(a); got.Len() != 0 {
+		t.Errorf("difference with itself should be empty: %#v", got.Flatten())
+	}
+}
+
+func TestRetain(t *testing.T) {
+	newTree := func(vals ...int) *RBTree[int] {
+		tree := New(cmp.Compare[int])
+		for _, v := range vals {
+			tree.Insert(v)
+		}
+		return tree
+	}
+
+	tests := []struct {
+		name   string
+		a, b   []int
+		want   []int
+		remove int
+	}{
+		{"Overlapping", []int{1, 2, 3, 4, 5, 6, 7, 8}, []int{2, 4, 6, 10}, []int{2, 4, 6}, 5},
+		{"Few", []int{1, 2, 3, 4, 5, 6, 7, 8}, []int{0, 1, 2, 3, 4, 5, 6, 9}, []int{1, 2, 3, 4, 5, 6}, 2},
+		{"Disjoint", []int{1, 3, 5}, []int{2, 4, 6}, nil, 3},
+		{"Same", []int{1, 3, 5}, []int{1, 3, 5}, []int{1, 3, 5}, 0},
+		{"EmptyOther", []int{1, 3, 5}, nil, nil, 3},
+		{"EmptyReceiver", nil, []int{1, 3, 5}, nil, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := newTree(test.a...), newTree(test.b...)
+			if removed := a.Retain(b); removed != test.remove {
+				t.Errorf("want: %d removed got: %d", test.remove, removed)
+			}
+			isRedBlackTree(t, a, a.root)
+			if out := a.Flatten(); !slices.Equal(out, test.want) {
+				t.Errorf("slices differ:\n%#v\n%#v", out, test.want)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	type edit struct {
+		added, removed []int
+	}
+	edits := []edit{
+		{[]int{100, 101}, nil},
+		{nil, []int{0, 50, 99}},
+		{[]int{-5, 50}, []int{101, 7}},
+		{nil, nil},
+	}
+
+	versions := []*RBTree[int]{New(cmp.Compare[int])}
+	for i := 0; i < 100; i++ {
+		versions[0].Insert(i)
+	}
+	for _, e := range edits {
+		next := NewFromUnsorted(cmp.Compare[int], versions[len(versions)-1].Flatten())
+		for _, v := range e.added {
+			next.Insert(v)
+		}
+		for _, v := range e.removed {
+			next.Delete(v)
+		}
+		versions = append(versions, next)
+	}
+
+	for i, e := range edits {
+		added, removed := Diff(versions[i], versions[i+1])
+		want := slices.Clone(e.added)
+		slices.Sort(want)
+		if got := runIterator(added); !slices.Equal(got, want) {
+			t.Errorf("version %d added slices differ:\n%#v\n%#v", i+1, got, want)
+		}
+		want = slices.Clone(e.removed)
+		slices.Sort(want)
+		if got := runIterator(removed); !slices.Equal(got, want) {
+			t.Errorf("version %d removed slices differ:\n%#v\n%#v", i+1, got, want)
+		}
+	}
+
+	// across the whole chain 50 was removed and added back
+	added, removed := Diff(versions[0], versions[len(versions)-1])
+	if got, want := runIterator(added), []int{-5, 100}; !slices.Equal(got, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", got, want)
+	}
+	if got, want := runIterator(removed), []int{0, 7, 99}; !slices.Equal(got, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", got, want)
+	}
+
+	added, _ = Diff(versions[0], versions[1])
+	var first []int
+	added(func(v int) bool {
+		first = append(first, v)
+		return false
+	})
+	if !slices.Equal(first, []int{100}) {
+		t.Errorf("slices differ:\n%#v\n%#v", first, []int{100})
+	}
+}
+
+// identicalSubtrees reports whether the subtrees rooted at x and y have
+// the same shape and values.
+func identicalSubtrees(a, b *RBTree[int], x, y *Node[int]) bool {
+	if x == a.nil || y == b.nil {
+		return x == a.nil && y == b.nil
+	}
+	return x.Value == y.Value && identicalSubtrees(a, b, x.left, y.left) && identicalSubtrees(a, b, x.right, y.right)
+}
+
+func TestSharedSubtree(t *testing.T) {
+	seq := func(lo, hi int) []int {
+		var vals []int
+		for i := lo; i <= hi; i++ {
+			vals = append(vals, i)
+		}
+		return vals
+	}
+
+	a := NewFromUnsorted(cmp.Compare[int], seq(1, 15))
+	t.Run("WholeTree", func(t *testing.T) {
+		// a perfect tree of 1..7 is the left half of the one of 1..15
+		b := NewFromUnsorted(cmp.Compare[int], seq(1, 7))
+		if got := SharedSubtree(a, b); got != a.Search(4) || got.Size() != 7 {
+			t.Errorf("want: the subtree at 4 got: %v", got)
+		}
+		if got := SharedSubtree(b, a); got != b.root {
+			t.Errorf("want: the root of b got: %v", got)
+		}
+		if got := SharedSubtree(a, a); got != a.root {
+			t.Errorf("want: the root of a got: %v", got)
+		}
+	})
+
+	t.Run("Disjoint", func(t *testing.T) {
+		b := NewFromUnsorted(cmp.Compare[int], seq(100, 120))
+		if got := SharedSubtree(a, b); got != nil {
+			t.Errorf("want: nil got: %v", got.Value)
+		}
+		if got := SharedSubtree(a, New(cmp.Compare[int])); got != nil {
+			t.Errorf("want: nil got: %v", got.Value)
+		}
+	})
+
+	t.Run("BruteForce", func(t *testing.T) {
+		for round := 0; round < 20; round++ {
+			a, b := New(cmp.Compare[int]), New(cmp.Compare[int])
+			for _, v := range rand.Perm(60) {
+				a.Insert(v)
+			}
+			for _, v := range rand.Perm(60)[:40] {
+				b.Insert(v)
+			}
+
+			want := 0
+			ia := inOrderIter[int]{tree: a}
+			ia.nodes(func(x *Node[int]) bool {
+				ib := inOrderIter[int]{tree: b}
+				ib.nodes(func(y *Node[int]) bool {
+					if x.size > want && identicalSubtrees(a, b, x, y) {
+						want = x.size
+					}
+					return true
+				})
+				return true
+			})
+
+			got := SharedSubtree(a, b)
+			if got.Size() != want {
+				t.Fatalf("want a subtree of %d nodes got: %d", want, got.Size())
+			}
+			if got != nil && !identicalSubtrees(a, b, got, b.Search(got.Value)) {
+				t.Fatalf("subtree at %d is not shared", got.Value)
+			}
+		}
+	})
+}
+
+func TestEqual(t *testing.T) {
+	type record struct {
+		id      int
+		payload string
+	}
+	byID := func(a, b record) int { return cmp.Compare(a.id, b.id) }
+	sameRecord := func(a, b record) bool { return a == b }
+
+	for _, withEquality := range []bool{false, true} {
+		var opts []Option[record]
+		name := "KeyOnly"
+		if withEquality {
+			opts = append(opts, WithEquality(sameRecord))
+			name = "WithEquality"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			a, b := New(byID, opts...), New(byID, opts...)
+			for i := 0; i < 10; i++ {
+				a.Insert(record{i, "v1"})
+				b.Insert(record{i, "v1"})
+			}
+			if !a.Equal(b) || !b.Equal(a) {
+				t.Error("identical trees should be equal")
+			}
+
+			// same keys, one changed payload
+			b.Delete(record{id: 4})
+			b.Insert(record{4, "v2"})
+			if got := a.Equal(b); got == withEquality {
+				t.Errorf("want: %t got: %t", !withEquality, got)
+			}
+			added, removed := Diff(a, b)
+			wantAdded, wantRemoved := []record(nil), []record(nil)
+			if withEquality {
+				wantAdded, wantRemoved = []record{{4, "v2"}}, []record{{4, "v1"}}
+			}
+			var gotAdded, gotRemoved []record
+			added(func(v record) bool { gotAdded = append(gotAdded, v); return true })
+			removed(func(v record) bool { gotRemoved = append(gotRemoved, v); return true })
+			if !slices.Equal(gotAdded, wantAdded) || !slices.Equal(gotRemoved, wantRemoved) {
+				t.Errorf("unexpected diff: %#v %#v", gotAdded, gotRemoved)
+			}
+
+			// different keys are never equal
+			b.Delete(record{id: 9})
+			b.Insert(record{10, "v1"})
+			if a.Equal(b) {
+				t.Error("trees with different keys should differ")
+			}
+			b.Delete(record{id: 10})
+			if a.Equal(b) {
+				t.Error("trees of different lengths should differ")
+			}
+		})
+	}
+
+	if !New(cmp.Compare[int]).Equal(New(cmp.Compare[int])) {
+		t.Error("empty trees should be equal")
+	}
+}
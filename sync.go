@@ -0,0 +1,168 @@
+package rbtree
+
+import (
+	"slices"
+	"sync"
+)
+
+// SyncRBTree wraps a red black tree with a sync.RWMutex so it can be
+// used from multiple goroutines. Node pointers are not exposed since
+// they cannot be used safely without holding the lock.
+type SyncRBTree[T any] struct {
+	mu   sync.RWMutex
+	tree *RBTree[T]
+}
+
+// NewSync constructs a SyncRBTree, see New.
+func NewSync[T any](compare func(a, b T) int, opts ...Option[T]) *SyncRBTree[T] {
+	return &SyncRBTree[T]{tree: New(compare, opts...)}
+}
+
+// Insert val, returns an ErrDuplicate if it's already present.
+func (s *SyncRBTree[T]) Insert(val T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.tree.InsertChecked(val)
+	return err
+}
+
+// Delete val, returns false if it was not present.
+func (s *SyncRBTree[T]) Delete(val T) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.Delete(val)
+}
+
+// Has reports whether val is present.
+func (s *SyncRBTree[T]) Has(val T) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.Has(val)
+}
+
+// Len returns the number of values in the tree.
+func (s *SyncRBTree[T]) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.Len()
+}
+
+// Flatten returns a sorted snapshot of the values in the tree.
+func (s *SyncRBTree[T]) Flatten() []T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.Flatten()
+}
+
+// ReplaceAll replaces the contents of the tree with vals, see
+// RBTree.ReplaceAll. The values are sorted before the lock is taken and
+// readers see either all of the old values or all of the new ones.
+func (s *SyncRBTree[T]) ReplaceAll(vals []T) {
+	sorted := s.tree.sortedCopy(vals)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tree.replaceSorted(sorted)
+}
+
+// Iterate over a snapshot of the tree with the desired iteration method.
+// The snapshot is taken when iteration starts so yield may safely call
+// back into the SyncRBTree.
+func (s *SyncRBTree[T]) Iterate(method IterationMethod) func(func(T) bool) {
+	return func(yield func(T) bool) {
+		s.mu.RLock()
+		var snapshot []T
+		s.tree.Iterate(method)(func(v T) bool {
+			snapshot = append(snapshot, v)
+			return true
+		})
+		s.mu.RUnlock()
+
+		for _, v := range snapshot {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// StripedSyncRBTree partitions values across several independently
+// locked trees so that writes to different partitions can proceed in
+// parallel. Point operations only lock the partition the value belongs
+// to, ordered scans merge the partitions back together.
+type StripedSyncRBTree[T any] struct {
+	compare   func(a, b T) int
+	partition func(T) uint64
+	shards    []*SyncRBTree[T]
+}
+
+// NewStripedSync constructs a StripedSyncRBTree with n partitions.
+// partition must map values that compare equal to the same number, a
+// hash of the value's key is a good choice.
+func NewStripedSync[T any](compare func(a, b T) int, n int, partition func(T) uint64, opts ...Option[T]) *StripedSyncRBTree[T] {
+	if n <= 0 {
+		panic("partition count must be positive")
+	}
+
+	s := &StripedSyncRBTree[T]{
+		compare:   compare,
+		partition: partition,
+		shards:    make([]*SyncRBTree[T], n),
+	}
+	for i := range s.shards {
+		s.shards[i] = NewSync(compare, opts...)
+	}
+	return s
+}
+
+func (s *StripedSyncRBTree[T]) shard(val T) *SyncRBTree[T] {
+	return s.shards[s.partition(val)%uint64(len(s.shards))]
+}
+
+// Insert val, returns an ErrDuplicate if it's already present.
+func (s *StripedSyncRBTree[T]) Insert(val T) error {
+	return s.shard(val).Insert(val)
+}
+
+// Delete val, returns false if it was not present.
+func (s *StripedSyncRBTree[T]) Delete(val T) bool {
+	return s.shard(val).Delete(val)
+}
+
+// Has reports whether val is present.
+func (s *StripedSyncRBTree[T]) Has(val T) bool {
+	return s.shard(val).Has(val)
+}
+
+// Len returns the number of values across all partitions. The
+// partitions are counted one at a time so concurrent writes may or may
+// not be reflected.
+func (s *StripedSyncRBTree[T]) Len() int {
+	total := 0
+	for _, shard := range s.shards {
+		total += shard.Len()
+	}
+	return total
+}
+
+// Flatten returns a sorted snapshot of the values across all partitions.
+// Each partition is snapshotted separately, the result is not a single
+// point in time view when there are concurrent writes.
+func (s *StripedSyncRBTree[T]) Flatten() []T {
+	var out []T
+	for _, shard := range s.shards {
+		out = append(out, shard.Flatten()...)
+	}
+	slices.SortFunc(out, s.compare)
+	return out
+}
+
+// Iterate over a sorted snapshot of the values, see Flatten.
+func (s *StripedSyncRBTree[T]) Iterate() func(func(T) bool) {
+	return func(yield func(T) bool) {
+		for _, v := range s.Flatten() {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package rbtree
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestSyncRBTree(t *testing.T) {
+	tree := NewSync(cmp.Compare[int])
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if err := tree.Insert(g*100 + i); err != nil {
+					t.Error(err)
+				}
+				tree.Has(i)
+			}
+			for i := 0; i < 100; i += 2 {
+				if !tree.Delete(g*100 + i) {
+					t.Errorf("failed to delete: %d", g*100+i)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if tree.Len() != 400 {
+		t.Errorf("want: 400 got: %d", tree.Len())
+	}
+	var dupErr ErrDuplicate[int]
+	if err := tree.Insert(1); !errors.As(err, &dupErr) {
+		t.Errorf("expected ErrDuplicate, got: %v", err)
+	}
+
+	out := runIterator(tree.Iterate(InOrder))
+	if !slices.IsSorted(out) || len(out) != 400 {
+		t.Errorf("bad iteration: %d values", len(out))
+	}
+}
+
+func TestStripedSyncRBTree(t *testing.T) {
+	tree := NewStripedSync(cmp.Compare[int], 4, func(v int) uint64 { return mix64(uint64(v)) })
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if err := tree.Insert(g*100 + i); err != nil {
+					t.Error(err)
+				}
+			}
+			for i := 0; i < 100; i += 2 {
+				if !tree.Delete(g*100 + i) {
+					t.Errorf("failed to delete: %d", g*100+i)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if tree.Len() != 400 {
+		t.Errorf("want: 400 got: %d", tree.Len())
+	}
+
+	var want []int
+	for i := 1; i < 800; i += 2 {
+		want = append(want, i)
+	}
+	if out := runIterator(tree.Iterate()); !slices.Equal(out, want) {
+		t.Errorf("slices differ:\n%#v\n%#v", out, want)
+	}
+	if !tree.Has(401) || tree.Has(400) {
+		t.Error("membership wrong")
+	}
+	for i, shard := range tree.shards {
+		if shard.Len() == 0 {
+			t.Errorf("shard %d was never used", i)
+		}
+	}
+}
+
+// Run these with -race to compare contention under the race detector:
+//
+//	go test -race -run=^$ -bench=BenchmarkConcurrentWrites
+func BenchmarkConcurrentWrites(b *testing.B) {
+	type inserter interface {
+		Insert(int64) error
+		Delete(int64) bool
+	}
+	run := func(b *testing.B, tree inserter) {
+		var mu sync.Mutex
+		var next int64
+		b.RunParallel(func(pb *testing.PB) {
+			// int64 keys so the bases stay apart on 32 bit platforms
+			mu.Lock()
+			base := next << 32
+			next++
+			mu.Unlock()
+
+			var i int64
+			for pb.Next() {
+				v := base + i
+				_ = tree.Insert(v)
+				if i%2 == 1 {
+					tree.Delete(v)
+				}
+				i++
+			}
+		})
+	}
+
+	b.Run("SingleLock", func(b *testing.B) {
+		run(b, NewSync(cmp.Compare[int64]))
+	})
+	b.Run("Striped16", func(b *testing.B) {
+		run(b, NewStripedSync(cmp.Compare[int64], 16, func(v int64) uint64 { return mix64(uint64(v)) }))
+	})
+}
+
+// TestSyncReplaceAll is best run with -race.
+func TestSyncReplaceAll(t *testing.T) {
+	contents := make([][]int, 2)
+	for i := range contents {
+		for v := 0; v < 500; v++ {
+			contents[i] = append(contents[i], i*1000+v)
+		}
+	}
+	tree := NewSync(cmp.Compare[int])
+	tree.ReplaceAll(contents[0])
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				got := tree.Flatten()
+				if !slices.Equal(got, contents[0]) && !slices.Equal(got, contents[1]) {
+					t.Errorf("saw a partial tree of %d values", len(got))
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		tree.ReplaceAll(contents[i%2])
+	}
+	close(done)
+	wg.Wait()
+
+	if got := tree.Flatten(); !slices.Equal(got, contents[1]) {
+		t.Errorf("slices differ:\n%#v\n%#v", got, contents[1])
+	}
+}